@@ -0,0 +1,21 @@
+// Posts the token from a verification link to the API and reports the result.
+(async () => {
+  const status = document.getElementById("status");
+  const token = new URLSearchParams(location.search).get("token");
+  if (!token) {
+    status.textContent = "This link has no verification token.";
+    return;
+  }
+  const resp = await fetch("/api/v1/users/verify", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ token }),
+  });
+  if (resp.ok) {
+    const user = await resp.json();
+    status.textContent = `${user.email} is verified.`;
+  } else {
+    const problem = await resp.json().catch(() => ({}));
+    status.textContent = problem.detail || "Verification failed.";
+  }
+})();
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// chirpCacheControl lets browsers and shared caches keep chirps but
+	// makes them revalidate every time, so a deleted chirp disappears at
+	// once. Revalidating is cheap: an unchanged chirp gets a 304.
+	chirpCacheControl = "public, no-cache"
+	// pageCacheControl is for the /app/ pages, which change on deploy.
+	pageCacheControl = "public, no-cache"
+	// assetCacheControl is for the images and other files under
+	// /app/assets/.
+	assetCacheControl = "public, max-age=86400"
+)
+
+// chirpRepresentation names the JSON shape the chirp ETags describe. Change
+// it whenever the Chirp type's encoding changes, so clients holding the old
+// shape do not get a 304.
+const chirpRepresentation = "chirp.v1"
+
+// chirpsETag is a strong validator for a list of chirps, in order. Chirp
+// bodies never change without updated_at changing, so each chirp's ID and
+// updated_at stand in for its content.
+func chirpsETag(chirps []Chirp) string {
+	h := sha256.New()
+	h.Write([]byte(chirpRepresentation))
+	for _, c := range chirps {
+		h.Write(c.ID[:])
+		h.Write(binary.BigEndian.AppendUint64(nil, uint64(c.UpdatedAt.UnixNano())))
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+func chirpETag(c Chirp) string {
+	return chirpsETag([]Chirp{c})
+}
+
+// checkNotModified sets the cache validators on the response, then
+// evaluates the request's If-None-Match or, failing that, If-Modified-Since
+// (RFC 9110 section 13.2.2). If the client's copy is current it writes a 304
+// and returns true. modified may be zero when there is no meaningful
+// modification time.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time, cacheControl string) bool {
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", cacheControl)
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else {
+		ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || modified.IsZero() || modified.Truncate(time.Second).After(ims) {
+			return false
+		}
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether the If-None-Match list matches etag, using the
+// weak comparison RFC 9110 prescribes for it.
+func etagMatches(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// withCacheControl sets Cache-Control on next's responses. The file server
+// removes it again from error responses.
+func withCacheControl(value string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", value)
+		next.ServeHTTP(w, r)
+	})
+}
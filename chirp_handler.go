@@ -1,18 +1,23 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"net/http"
 	"sort"
 	"strings"
 	"time"
 
-	"github.com/05blue04/chirpy/internal/auth"
 	"github.com/05blue04/chirpy/internal/database"
 	"github.com/google/uuid"
 )
 
+var errNotChirpAuthor = errors.New("chirp belongs to another user")
+
+func errChirpNotFound(cause error) *appError {
+	return newAppError(codeNotFound, "No chirp with that ID exists.", cause)
+}
+
 type Chirp struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
@@ -21,34 +26,21 @@ type Chirp struct {
 	UserID    uuid.UUID `json:"user_id"`
 }
 
-func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Body string `json:"body"`
-	}
-
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "error extracting bearer from request", err)
-		return
-	}
+type createChirpRequest struct {
+	Body string `json:"body" validate:"required,max=140"`
+}
 
-	userID, err := auth.ValidateJWT(token, cfg.secret)
+func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "unable to grant access", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-
-	err = decoder.Decode(&params)
+	params := createChirpRequest{}
+	err = cfg.decodeJSON(w, r, &params)
 	if err != nil {
-		respondWithError(w, 400, "Error decoding body", err)
-		return
-	}
-
-	if len(params.Body) > 140 {
-		respondWithError(w, 400, "Chirp is too long", nil)
+		respondWithError(w, r, err)
 		return
 	}
 
@@ -59,7 +51,7 @@ func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request)
 	}
 	clean := cleanString(params.Body, blocked)
 
-	c, err := cfg.db.CreateChirp(context.Background(), database.CreateChirpParams{
+	c, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
 		ID:        uuid.New(),
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -68,17 +60,20 @@ func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request)
 	})
 
 	if err != nil {
-		respondWithError(w, 400, "error creating chirp", err)
+		respondWithError(w, r, err)
 		return
 	}
+	cfg.metrics.chirpsCreated.Inc()
 
-	respondWithJSON(w, 201, Chirp{
+	chirp := Chirp{
 		ID:        c.ID,
 		CreatedAt: c.CreatedAt,
 		UpdatedAt: c.UpdatedAt,
 		Body:      c.Body,
 		UserID:    c.UserID,
-	})
+	}
+	cfg.publish(r.Context(), chirpEvent(eventChirpCreated, chirp), chirp)
+	respondWithJSON(w, 201, chirp)
 
 }
 
@@ -106,26 +101,26 @@ func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, r *http.Request) {
 	if authIDStr != "" {
 		err := uuid.Validate(authIDStr)
 		if err != nil {
-			respondWithError(w, http.StatusBadRequest, "invalid author_id uuid", err)
+			respondWithError(w, r, errInvalidUUID("author_id", err))
 			return
 		}
 
 		authID, err := uuid.Parse(authIDStr)
 		if err != nil {
-			respondWithError(w, http.StatusBadRequest, "error parsing author_id", err)
+			respondWithError(w, r, errInvalidUUID("author_id", err))
 			return
 		}
 
 		chirps, err = cfg.db.GetChirpsByAuthID(r.Context(), authID)
 		if err != nil {
-			respondWithError(w, 500, "error getting chirps by authID", err)
+			respondWithError(w, r, err)
 			return
 		}
 
 	} else {
 		chirps, err = cfg.db.GetChirps(r.Context())
 		if err != nil {
-			respondWithError(w, 500, "error getting chirps from db", err)
+			respondWithError(w, r, err)
 			return
 		}
 	}
@@ -147,6 +142,11 @@ func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	// No Last-Modified: deleting a chirp changes the list without making
+	// any remaining chirp newer, so only the ETag can tell.
+	if checkNotModified(w, r, chirpsETag(jsonChirps), time.Time{}, chirpCacheControl) {
+		return
+	}
 	respondWithJSON(w, http.StatusOK, jsonChirps)
 }
 
@@ -155,42 +155,43 @@ func (cfg *apiConfig) getChirpByIDHandler(w http.ResponseWriter, r *http.Request
 
 	err := uuid.Validate(chirpID)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "invalid uuid in request", err)
+		respondWithError(w, r, errInvalidUUID("chirpID", err))
 		return
 	}
 
 	chirpUUID, err := uuid.Parse(chirpID)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "error converting id to uuid", err)
+		respondWithError(w, r, errInvalidUUID("chirpID", err))
 		return
 	}
 
-	c, err := cfg.db.GetChirpById(context.Background(), chirpUUID)
+	c, err := cfg.db.GetChirpById(r.Context(), chirpUUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, errChirpNotFound(err))
+		return
+	}
 	if err != nil {
-		respondWithError(w, 404, "Chirp with requested id does not exist", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, Chirp{
+	chirp := Chirp{
 		ID:        c.ID,
 		CreatedAt: c.CreatedAt,
 		UpdatedAt: c.UpdatedAt,
 		Body:      c.Body,
 		UserID:    c.UserID,
-	})
-
-}
-
-func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "error extracting bearer from request", err)
+	}
+	if checkNotModified(w, r, chirpETag(chirp), chirp.UpdatedAt, chirpCacheControl) {
 		return
 	}
+	respondWithJSON(w, http.StatusOK, chirp)
+}
 
-	userID, err := auth.ValidateJWT(token, cfg.secret)
+func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "unable to grant access", err)
+		respondWithError(w, r, err)
 		return
 	}
 
@@ -198,33 +199,44 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request)
 
 	err = uuid.Validate(chirpIDstring)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "invalid uuid in request", err)
+		respondWithError(w, r, errInvalidUUID("chirpID", err))
 		return
 	}
 
 	chirpID, err := uuid.Parse(chirpIDstring)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "error converting id to uuid", err)
+		respondWithError(w, r, errInvalidUUID("chirpID", err))
 		return
 	}
 
-	chirp, err := cfg.db.GetChirpById(r.Context(), chirpID)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "invalid chirpID", err)
+	// Lock the chirp while checking its author so the delete acts on what
+	// was checked.
+	var deleted database.Chirp
+	err = cfg.db.WithTx(r.Context(), func(q database.Querier) error {
+		chirp, err := q.GetChirpByIdForUpdate(r.Context(), chirpID)
+		if err != nil {
+			return err
+		}
+		deleted = chirp
+		if chirp.UserID != userID {
+			return errNotChirpAuthor
+		}
+		return q.DeleteChirpByID(r.Context(), chirpID)
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		respondWithError(w, r, errChirpNotFound(err))
 		return
-	}
-
-	if chirp.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "Only users the author of this chirp can delete", err)
+	case errors.Is(err, errNotChirpAuthor):
+		respondWithError(w, r, newAppError(codeForbidden, "Only the author of a chirp can delete it.", err))
 		return
-	}
-
-	err = cfg.db.DeleteChirpByID(r.Context(), chirpID)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "invalid chirpID", err)
+	case err != nil:
+		respondWithError(w, r, err)
 		return
 	}
 
+	cfg.publish(r.Context(), chirpEvent(eventChirpDeleted, Chirp{ID: deleted.ID, Body: deleted.Body, UserID: deleted.UserID}),
+		chirpDeletedEvent{ID: deleted.ID, UserID: deleted.UserID})
 	w.WriteHeader(http.StatusNoContent)
 
 }
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoder is the part of the gzip, brotli and zstd writers the middleware
+// uses. All three can be Reset onto a new writer, which is what makes them
+// worth pooling: each holds hundreds of kilobytes of window and tables.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// contentCoding is one Content-Encoding the server can produce, with a pool
+// of its encoders.
+type contentCoding struct {
+	name       string
+	newEncoder func() encoder
+	pool       sync.Pool
+}
+
+func (c *contentCoding) get(w io.Writer) encoder {
+	enc, ok := c.pool.Get().(encoder)
+	if !ok {
+		enc = c.newEncoder()
+	}
+	enc.Reset(w)
+	return enc
+}
+
+func (c *contentCoding) put(enc encoder) {
+	// Drop the reference to the response so it can be collected.
+	enc.Reset(io.Discard)
+	c.pool.Put(enc)
+}
+
+// contentCodings are in the server's order of preference, used to break
+// ties between codings the client weights equally. The levels favour speed,
+// since responses are compressed on every request.
+var contentCodings = []*contentCoding{
+	{name: "zstd", newEncoder: func() encoder {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+		if err != nil {
+			panic(err)
+		}
+		return enc
+	}},
+	{name: "br", newEncoder: func() encoder {
+		return brotli.NewWriterLevel(nil, 4)
+	}},
+	{name: "gzip", newEncoder: func() encoder {
+		enc, err := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		if err != nil {
+			panic(err)
+		}
+		return enc
+	}},
+}
+
+// negotiateEncoding picks the coding the client weights highest in its
+// Accept-Encoding header (RFC 9110 section 12.5.3), or nil if it accepts
+// none of them and should get the identity encoding.
+func negotiateEncoding(accept string) *contentCoding {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(k), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		weights[name] = q
+	}
+
+	var best *contentCoding
+	bestQ := 0.0
+	for _, c := range contentCodings {
+		q, ok := weights[c.name]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether a response of this type is worth
+// compressing. Images other than SVG, archives and fonts are already
+// compressed, so they are left alone; so is text/event-stream, whose events
+// must reach the client as soon as they are flushed.
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mt == "text/event-stream":
+		return false
+	case strings.HasPrefix(mt, "text/"), strings.HasSuffix(mt, "+json"), strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	switch mt {
+	case "application/json", "application/javascript", "application/xml", "application/wasm":
+		return true
+	}
+	return false
+}
+
+// middlewareCompress encodes responses with the best coding the client
+// accepts. Bodies shorter than the configured minimum are sent as they are,
+// since the encoding overhead would outweigh the saving, as are responses
+// that are already encoded or of a type that doesn't compress.
+func (cfg *apiConfig) middlewareCompress(next http.Handler) http.Handler {
+	conf := cfg.conf.Compression
+	if !conf.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &compressWriter{ResponseWriter: w, minSize: conf.MinSize}
+		// Ranges of a HEAD or partial response refer to the unencoded
+		// body, so only whole GETs and the like are compressed.
+		if r.Method != http.MethodHead && r.Header.Get("Range") == "" {
+			cw.coding = negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter holds back the start of the response until it has seen
+// enough of it to decide whether to compress. Until then the status and
+// body are kept in status and buf.
+type compressWriter struct {
+	http.ResponseWriter
+	coding  *contentCoding
+	minSize int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	if code >= 100 && code < 200 {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.status = code
+
+	h := cw.Header()
+	switch {
+	case code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent:
+		cw.start(false)
+	case cw.coding == nil || h.Get("Content-Encoding") != "":
+		cw.start(false)
+	case h.Get("Content-Type") != "" && !compressible(h.Get("Content-Type")):
+		cw.start(false)
+	default:
+		if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < cw.minSize {
+			cw.start(false)
+		}
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case cw.enc != nil:
+		return cw.enc.Write(b)
+	case cw.decided:
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// start sends the header and anything buffered, compressing from here on if
+// compress is true and the response turns out to be compressible.
+func (cw *compressWriter) start(compress bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 && h.Get("Content-Encoding") == "" {
+		// net/http would sniff it anyway; do it now to know what it is.
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	// The body varies with Accept-Encoding whenever it could have been
+	// compressed, whether or not this one was. A 304 stands in for such a
+	// body, so it varies too.
+	varies := h.Get("Content-Encoding") == "" &&
+		(cw.status == http.StatusNotModified || compressible(h.Get("Content-Type")))
+	if varies {
+		h.Add("Vary", "Accept-Encoding")
+	}
+
+	if compress && varies && cw.coding != nil {
+		// A strong ETag names exact bytes, which differ between codings.
+		// The weak form still revalidates, since If-None-Match uses weak
+		// comparison.
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+		h.Set("Content-Encoding", cw.coding.name)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		cw.ResponseWriter.WriteHeader(cw.status)
+		cw.enc = cw.coding.get(cw.ResponseWriter)
+		_, err := cw.enc.Write(cw.buf)
+		cw.buf = nil
+		return err
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.ResponseWriter.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// FlushError sends what has been written so far. Flushing before the
+// minimum size is reached commits to compressing, as it means the handler is
+// streaming.
+func (cw *compressWriter) FlushError() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.WriteHeader(http.StatusOK)
+		}
+		if !cw.decided {
+			if err := cw.start(true); err != nil {
+				return err
+			}
+		}
+	}
+	if cw.enc != nil {
+		if err := cw.enc.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Flush() {
+	cw.FlushError()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the response once the handler returns: it sends a short
+// body that never reached the threshold, or ends the compressed stream.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 {
+			// Nothing was written; net/http will send an empty 200.
+			return
+		}
+		cw.start(false)
+		return
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+		cw.coding.put(cw.enc)
+		cw.enc = nil
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/05blue04/chirpy/internal/store"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip, deflate, br, zstd", "zstd"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"GZIP;Q=0.8", "gzip"},
+		{"zstd;q=0, br;q=0, gzip;q=0", ""},
+		{"*", "zstd"},
+		{"*;q=0.1, br", "br"},
+		{"*, zstd;q=0", "br"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if c := negotiateEncoding(tt.accept); c != nil {
+			got = c.name
+		}
+		if got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	h := cfg.routes()
+
+	serve := func(path, accept string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	spec := serve("/api/openapi.json", "", nil)
+	if len(spec.Body.Bytes()) < cfg.conf.Compression.MinSize {
+		t.Fatalf("spec is only %d bytes; pick a larger response", spec.Body.Len())
+	}
+	if enc := spec.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("Content-Encoding = %q without Accept-Encoding", enc)
+	}
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+	for name, decode := range decoders {
+		t.Run(name, func(t *testing.T) {
+			// Twice, so the second response comes from a pooled encoder.
+			for range 2 {
+				rec := serve("/api/openapi.json", name, nil)
+				if enc := rec.Header().Get("Content-Encoding"); enc != name {
+					t.Fatalf("Content-Encoding = %q, want %q", enc, name)
+				}
+				if rec.Header().Get("Content-Length") != "" {
+					t.Error("compressed response has a Content-Length")
+				}
+				if !strings.Contains(rec.Header().Get("Vary"), "Accept-Encoding") {
+					t.Errorf("Vary = %q", rec.Header().Values("Vary"))
+				}
+				r, err := decode(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(body, spec.Body.Bytes()) {
+					t.Fatal("decoded body differs from the identity response")
+				}
+			}
+		})
+	}
+
+	small := serve("/api/livez", "gzip", nil)
+	if enc := small.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("response below the threshold has Content-Encoding %q", enc)
+	}
+	if !strings.Contains(small.Header().Get("Vary"), "Accept-Encoding") {
+		t.Error("response below the threshold does not Vary on Accept-Encoding")
+	}
+
+	logo := serve("/app/assets/logo.png", "gzip, br, zstd", nil)
+	if logo.Code != http.StatusOK {
+		t.Fatalf("logo status = %d", logo.Code)
+	}
+	if enc := logo.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("PNG has Content-Encoding %q", enc)
+	}
+	if logo.Header().Get("Content-Length") == "" {
+		t.Error("PNG lost its Content-Length")
+	}
+
+	partial := serve("/app/", "gzip", http.Header{"Range": {"bytes=0-9"}})
+	if partial.Code != http.StatusPartialContent || partial.Header().Get("Content-Encoding") != "" {
+		t.Errorf("range request: status %d, Content-Encoding %q", partial.Code, partial.Header().Get("Content-Encoding"))
+	}
+}
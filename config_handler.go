@@ -0,0 +1,14 @@
+package main
+
+import "net/http"
+
+// configHandler shows the effective configuration. Even redacted it names
+// the database, mail server and limits, so like reset it is only served in
+// development.
+func (cfg *apiConfig) configHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.platform != "dev" {
+		respondWithError(w, r, newAppError(codeForbidden, "Configuration is only available when PLATFORM is dev.", nil))
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.conf.Redacted())
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/05blue04/chirpy/internal/store"
+)
+
+func TestConfigHandler(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	c := newTestClient(t, cfg)
+
+	resp := c.do("GET", "/admin/config", "", nil)
+	c.expect(resp, http.StatusOK, nil)
+	if strings.Contains(string(resp.body), testSecret) || strings.Contains(string(resp.body), testPolkaKey) {
+		t.Errorf("config view leaks a secret: %s", resp.body)
+	}
+
+	cfg.platform = "prod"
+	c.expectProblem(c.do("GET", "/admin/config", "", nil), codeForbidden)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/05blue04/chirpy/internal/validate"
+)
+
+// decodeJSON reads a single JSON object from the request body into dst, then
+// checks dst against its `validate` struct tags. The body must be sent as
+// application/json, fit in the configured size limit and use only the
+// fields dst declares. Failures come back as appErrors ready for
+// respondWithError.
+func (cfg *apiConfig) decodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	return cfg.decode(w, r, dst, true)
+}
+
+// decodeJSONAllowUnknown is decodeJSON for payloads we do not control, such
+// as webhooks, whose senders may add fields at any time.
+func (cfg *apiConfig) decodeJSONAllowUnknown(w http.ResponseWriter, r *http.Request, dst any) error {
+	return cfg.decode(w, r, dst, false)
+}
+
+func (cfg *apiConfig) decode(w http.ResponseWriter, r *http.Request, dst any, strict bool) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return newAppError(codeUnsupportedMedia, "Send the request body as application/json.", err)
+	}
+
+	limit := int64(cfg.conf.Server.MaxBodyBytes)
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	dec := json.NewDecoder(r.Body)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return decodeError(err)
+		}
+		return errMalformedBody(errors.New("body holds more than one JSON value"))
+	}
+
+	if err := validate.Struct(dst); err != nil {
+		var fields validate.Errors
+		if errors.As(err, &fields) {
+			return errValidation(fields)
+		}
+		return err
+	}
+	return nil
+}
+
+// decodeError explains why the body could not be decoded. Problems with a
+// single field are reported as field errors, like failed validation.
+func decodeError(err error) error {
+	var maxErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxErr):
+		return newAppError(codeBodyTooLarge, fmt.Sprintf("Request body must be at most %d bytes.", maxErr.Limit), err)
+	case errors.Is(err, io.EOF):
+		return errMalformedBody(errors.New("empty body"))
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return errValidation(validate.Errors{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: "must be " + jsonTypeName(typeErr.Type),
+		}})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for this; the message is stable.
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return errValidation(validate.Errors{{
+			Field:   field,
+			Rule:    "unknown",
+			Message: "is not a recognised field",
+		}})
+	default:
+		return errMalformedBody(err)
+	}
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
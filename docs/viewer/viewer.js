@@ -0,0 +1,132 @@
+// Renders the OpenAPI document at /api/openapi.json as a browsable API
+// reference: operations grouped by tag, with their parameters, request body
+// and responses, followed by the schemas they refer to. Everything is built
+// with DOM calls, never innerHTML, so text from the spec is never markup.
+(async () => {
+  const root = document.getElementById("reference");
+
+  const el = (tag, attrs = {}, ...children) => {
+    const node = document.createElement(tag);
+    for (const [k, v] of Object.entries(attrs)) node.setAttribute(k, v);
+    for (const child of children) {
+      if (child != null) node.append(child);
+    }
+    return node;
+  };
+
+  const schemaName = (ref) => ref.replace("#/components/schemas/", "");
+
+  // typeOf describes a schema in one line, linking to named schemas.
+  const typeOf = (schema) => {
+    if (!schema) return "any";
+    if (schema.$ref) {
+      const name = schemaName(schema.$ref);
+      return el("a", { href: `#schema-${name}` }, name);
+    }
+    if (schema.type === "array") {
+      return el("span", {}, "array of ", typeOf(schema.items));
+    }
+    if (schema.type === "object" && schema.additionalProperties) {
+      return el("span", {}, "map of ", typeOf(schema.additionalProperties));
+    }
+    let text = schema.type || "any";
+    if (schema.format) text += ` (${schema.format})`;
+    if (schema.enum) text += `: ${schema.enum.join(" | ")}`;
+    const limits = [];
+    if (schema.minLength != null) limits.push(`min ${schema.minLength}`);
+    if (schema.maxLength != null) limits.push(`max ${schema.maxLength}`);
+    if (limits.length) text += `, ${limits.join(", ")} chars`;
+    return text;
+  };
+
+  const fields = (schema) => {
+    const required = new Set(schema.required || []);
+    const rows = Object.entries(schema.properties || {}).map(([name, prop]) =>
+      el("tr", {},
+        el("td", {}, el("code", {}, name)),
+        el("td", {}, typeOf(prop)),
+        el("td", {}, required.has(name) ? "required" : "")));
+    return el("table", {}, ...rows);
+  };
+
+  const content = (media) => {
+    const parts = [];
+    for (const [type, { schema }] of Object.entries(media || {})) {
+      parts.push(el("span", { class: "media" }, type), " ", typeOf(schema));
+    }
+    return el("span", {}, ...parts);
+  };
+
+  const operation = (method, path, op) => {
+    const section = el("section", { class: op.deprecated ? "op deprecated" : "op" },
+      el("h3", {},
+        el("span", { class: `method ${method}` }, method.toUpperCase()), " ",
+        el("code", {}, path)),
+      op.summary && el("p", { class: "summary" }, op.summary),
+      op.description && el("p", {}, op.description));
+    if (op.deprecated) section.append(el("p", { class: "note" }, "Deprecated."));
+    if (op.security) {
+      const schemes = op.security.flatMap((s) => Object.keys(s));
+      section.append(el("p", { class: "note" }, `Authentication: ${schemes.join(", ")}`));
+    }
+    if (op.parameters) {
+      section.append(el("h4", {}, "Parameters"), el("table", {},
+        ...op.parameters.map((p) => el("tr", {},
+          el("td", {}, el("code", {}, p.name)),
+          el("td", {}, p.in),
+          el("td", {}, typeOf(p.schema)),
+          el("td", {}, p.required ? "required" : ""),
+          el("td", {}, p.description || "")))));
+    }
+    if (op.requestBody) {
+      section.append(el("h4", {}, "Request body"), el("p", {}, content(op.requestBody.content)));
+    }
+    section.append(el("h4", {}, "Responses"), el("table", {},
+      ...Object.entries(op.responses).map(([status, resp]) => el("tr", {},
+        el("td", {}, el("code", {}, status)),
+        el("td", {}, resp.description),
+        el("td", {}, content(resp.content))))));
+    return section;
+  };
+
+  let doc;
+  try {
+    const resp = await fetch("/api/openapi.json");
+    if (!resp.ok) throw new Error(`GET /api/openapi.json returned ${resp.status}`);
+    doc = await resp.json();
+  } catch (err) {
+    root.replaceChildren(el("p", { class: "note" }, `Could not load the API description: ${err.message}`));
+    return;
+  }
+
+  const byTag = new Map();
+  for (const path of Object.keys(doc.paths).sort()) {
+    for (const [method, op] of Object.entries(doc.paths[path])) {
+      const tag = (op.tags && op.tags[0]) || "other";
+      if (!byTag.has(tag)) byTag.set(tag, []);
+      byTag.get(tag).push(operation(method, path, op));
+    }
+  }
+
+  const nav = el("nav", {});
+  const body = [];
+  for (const [tag, ops] of byTag) {
+    nav.append(el("a", { href: `#tag-${tag}` }, tag));
+    body.push(el("h2", { id: `tag-${tag}` }, tag), ...ops);
+  }
+  nav.append(el("a", { href: "#schemas" }, "schemas"));
+  body.push(el("h2", { id: "schemas" }, "Schemas"));
+  for (const name of Object.keys(doc.components.schemas).sort()) {
+    const schema = doc.components.schemas[name];
+    body.push(el("section", { class: "schema", id: `schema-${name}` },
+      el("h3", {}, el("code", {}, name)),
+      schema.properties ? fields(schema) : el("p", {}, typeOf(schema))));
+  }
+
+  root.replaceChildren(
+    el("h1", {}, `${doc.info.title} ${doc.info.version}`),
+    doc.info.description && el("p", {}, doc.info.description),
+    el("p", {}, "Machine-readable document: ", el("a", { href: "/api/openapi.json" }, "/api/openapi.json")),
+    nav,
+    ...body);
+})();
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/auth"
+	"github.com/05blue04/chirpy/internal/config"
+	"github.com/05blue04/chirpy/internal/database"
+	"github.com/05blue04/chirpy/internal/mail"
+	"github.com/google/uuid"
+)
+
+// newMailer builds the mailer described by conf. A log file it opens stays
+// open for the life of the process.
+func newMailer(conf config.Mail) (mail.Mailer, error) {
+	if conf.Transport == "smtp" {
+		return mail.NewSMTP(conf.SMTP.Host, conf.SMTP.Port, conf.SMTP.Username, conf.SMTP.Password, conf.From), nil
+	}
+	var w io.Writer = os.Stderr
+	if conf.LogFile != "" {
+		f, err := os.OpenFile(conf.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return mail.NewWriter(w, conf.From), nil
+}
+
+// jobSendVerification is the kind of the job that mails a verification
+// link, so a slow or unreachable mail server never holds up a request and
+// failed sends are retried.
+const jobSendVerification = "send-verification-email"
+
+// verificationJob is the payload of a jobSendVerification job. It holds no
+// token: the link is signed when the job runs, so nothing that can verify
+// the address is ever stored.
+type verificationJob struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+}
+
+// queueVerification queues a mail to u's address with a link that verifies
+// it.
+func (cfg *apiConfig) queueVerification(ctx context.Context, u database.User) error {
+	return cfg.jobs.Enqueue(ctx, jobSendVerification, verificationJob{UserID: u.ID, Email: u.Email})
+}
+
+// sendVerification mails the link for a jobSendVerification job. The link
+// stops working after MAIL_VERIFY_TTL or once the address changes.
+func (cfg *apiConfig) sendVerification(ctx context.Context, payload json.RawMessage) error {
+	var job verificationJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	ttl := time.Duration(cfg.conf.Mail.VerifyTTL)
+	token, err := auth.MakeEmailToken(job.UserID, job.Email, cfg.secret, ttl)
+	if err != nil {
+		return err
+	}
+	link, err := url.Parse(cfg.conf.Mail.VerifyURL)
+	if err != nil {
+		return err
+	}
+	q := link.Query()
+	q.Set("token", token)
+	link.RawQuery = q.Encode()
+
+	return cfg.mailer.Send(ctx, mail.Message{
+		To:      job.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Open this link to verify your email address:\n\n%s\n\n"+
+			"It expires at %s. If you did not sign up for Chirpy, you can ignore this message.\n",
+			link, time.Now().Add(ttl).UTC().Format("2 Jan 2006 15:04 MST")),
+	})
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func errInvalidVerification(cause error) *appError {
+	return newAppError(codeInvalidToken, "Verification link is invalid, has expired or is for an address the account no longer uses.", cause)
+}
+
+// verifyEmailHandler marks the address a verification link was sent to as
+// verified, as long as the account still uses it.
+func (cfg *apiConfig) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	params := verifyEmailRequest{}
+	err := cfg.decodeJSON(w, r, &params)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	userID, email, err := auth.ValidateEmailToken(params.Token, cfg.secret)
+	if err != nil {
+		respondWithError(w, r, errInvalidVerification(err))
+		return
+	}
+	setRequestUser(r, userID)
+
+	u, err := cfg.db.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{ID: userID, Email: email})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, errInvalidVerification(err))
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, User{
+		ID:            u.ID,
+		CreatedAt:     u.CreatedAt,
+		UpdatedAt:     u.UpdatedAt,
+		Email:         u.Email,
+		Is_chirpy_red: u.IsChirpyRed,
+		EmailVerified: u.EmailVerifiedAt.Valid,
+	})
+}
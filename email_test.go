@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/config"
+	"github.com/05blue04/chirpy/internal/mail"
+	"github.com/05blue04/chirpy/internal/store"
+)
+
+// startJobs swaps cfg's queue for one that polls often and runs it until
+// the test ends.
+func startJobs(t *testing.T, cfg *apiConfig) {
+	t.Helper()
+	conf := cfg.conf.Jobs
+	conf.PollInterval = config.Duration(10 * time.Millisecond)
+	q, err := newJobQueue(conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.jobs = q
+	if err := cfg.registerJobs(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- q.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// mailQueued counts the verification mails cfg has queued.
+func mailQueued(t *testing.T, cfg *apiConfig) int {
+	t.Helper()
+	all, err := cfg.jobs.List(context.Background(), "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, j := range all {
+		if j.Kind == jobSendVerification {
+			n++
+		}
+	}
+	return n
+}
+
+// waitForMail waits until cfg's mailer has sent n messages and returns them.
+func waitForMail(t *testing.T, cfg *apiConfig, n int) []mail.Message {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sent := cfg.mailer.(*mail.Memory).Messages()
+		if len(sent) >= n {
+			return sent
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sent %d mails, want %d", len(sent), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// verificationToken returns the token from the link in msg.
+func verificationToken(t *testing.T, cfg *apiConfig, msg mail.Message) string {
+	t.Helper()
+	for _, line := range strings.Split(msg.Body, "\n") {
+		if strings.HasPrefix(line, cfg.conf.Mail.VerifyURL+"?") {
+			u, err := url.Parse(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return u.Query().Get("token")
+		}
+	}
+	t.Fatalf("no verification link in %q", msg.Body)
+	return ""
+}
+
+func TestEmailVerification(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	startJobs(t, cfg)
+	c := newTestClient(t, cfg)
+
+	u := c.signup("walt@example.com", "04234")
+	if u.EmailVerified {
+		t.Errorf("new user is verified: %+v", u)
+	}
+	sent := waitForMail(t, cfg, 1)
+	if len(sent) != 1 || sent[0].To != "walt@example.com" {
+		t.Fatalf("sent %+v, want one mail to walt@example.com", sent)
+	}
+	first := verificationToken(t, cfg, sent[0])
+
+	// Neither the queued job nor the admin listing holds anything that can
+	// verify the address.
+	queued, err := cfg.jobs.List(context.Background(), "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, j := range queued {
+		if strings.Contains(string(j.Payload), first) || strings.Contains(string(j.Payload), "token") {
+			t.Errorf("%s job payload holds a token: %s", j.Kind, j.Payload)
+		}
+	}
+	if resp := c.do("GET", "/admin/jobs?state=done", "", nil); strings.Contains(string(resp.body), first) {
+		t.Errorf("job listing includes the verification token: %s", resp.body)
+	}
+
+	s := c.login("walt@example.com", "04234")
+	c.expectProblem(c.do("POST", "/api/v1/users/verify", "", map[string]string{"token": "garbage"}), codeInvalidToken)
+	c.expectProblem(c.do("POST", "/api/v1/users/verify", "", map[string]string{"token": s.Token}), codeInvalidToken)
+	c.expectProblem(c.do("POST", "/api/v1/users/verify", "", map[string]string{}), codeValidationFailed)
+
+	var verified User
+	c.expect(c.do("POST", "/api/v1/users/verify", "", map[string]string{"token": first}), http.StatusOK, &verified)
+	if verified.ID != u.ID || !verified.EmailVerified {
+		t.Errorf("verify returned %+v", verified)
+	}
+	if s := c.login("walt@example.com", "04234"); !s.EmailVerified {
+		t.Errorf("login after verifying returned %+v", s)
+	}
+
+	// Keeping a verified address sends nothing.
+	var updated User
+	c.expect(c.do("PUT", "/api/v1/users", bearer(s.Token), map[string]string{"email": "walt@example.com", "password": "blue"}), http.StatusOK, &updated)
+	if !updated.EmailVerified {
+		t.Errorf("update with the same address returned %+v", updated)
+	}
+	if n := mailQueued(t, cfg); n != 1 {
+		t.Errorf("queued %d mails, want only the one from signup", n)
+	}
+
+	// A new address has to be verified again, and the old link no longer
+	// works.
+	c.expect(c.do("PUT", "/api/v1/users", bearer(s.Token), map[string]string{"email": "heisenberg@example.com", "password": "blue"}), http.StatusOK, &updated)
+	if updated.EmailVerified {
+		t.Errorf("update with a new address returned %+v", updated)
+	}
+	sent = waitForMail(t, cfg, 2)
+	if len(sent) != 2 || sent[1].To != "heisenberg@example.com" {
+		t.Fatalf("sent %+v, want a second mail to heisenberg@example.com", sent)
+	}
+	c.expectProblem(c.do("POST", "/api/v1/users/verify", "", map[string]string{"token": first}), codeInvalidToken)
+	c.expect(c.do("POST", "/api/v1/users/verify", "", map[string]string{"token": verificationToken(t, cfg, sent[1])}), http.StatusOK, &verified)
+	if verified.Email != "heisenberg@example.com" || !verified.EmailVerified {
+		t.Errorf("verify returned %+v", verified)
+	}
+}
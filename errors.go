@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/05blue04/chirpy/internal/validate"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// errorCode is a stable, machine-readable identifier for a kind of failure.
+// Clients may switch on it, so existing codes must not be renamed.
+type errorCode string
+
+const (
+	codeMalformedBody      errorCode = "malformed_body"
+	codeUnsupportedMedia   errorCode = "unsupported_media_type"
+	codeBodyTooLarge       errorCode = "body_too_large"
+	codeInvalidParameter   errorCode = "invalid_parameter"
+	codeValidationFailed   errorCode = "validation_failed"
+	codeUnauthenticated    errorCode = "unauthenticated"
+	codeInvalidCredentials errorCode = "invalid_credentials"
+	codeInvalidToken       errorCode = "invalid_token"
+	codeForbidden          errorCode = "forbidden"
+	codeNotFound           errorCode = "not_found"
+	codeEmailTaken         errorCode = "email_taken"
+	codeRateLimited        errorCode = "rate_limited"
+	codeInternal           errorCode = "internal"
+)
+
+type problemType struct {
+	status int
+	title  string
+}
+
+// problemTypes maps every errorCode to its HTTP status and the short,
+// human-readable summary sent as the problem's title.
+var problemTypes = map[errorCode]problemType{
+	codeMalformedBody:      {http.StatusBadRequest, "Malformed request body"},
+	codeUnsupportedMedia:   {http.StatusUnsupportedMediaType, "Unsupported media type"},
+	codeBodyTooLarge:       {http.StatusRequestEntityTooLarge, "Request body too large"},
+	codeInvalidParameter:   {http.StatusBadRequest, "Invalid parameter"},
+	codeValidationFailed:   {http.StatusBadRequest, "Validation failed"},
+	codeUnauthenticated:    {http.StatusUnauthorized, "Authentication required"},
+	codeInvalidCredentials: {http.StatusUnauthorized, "Invalid email or password"},
+	codeInvalidToken:       {http.StatusUnauthorized, "Invalid or expired token"},
+	codeForbidden:          {http.StatusForbidden, "Forbidden"},
+	codeNotFound:           {http.StatusNotFound, "Not found"},
+	codeEmailTaken:         {http.StatusConflict, "Email already in use"},
+	codeRateLimited:        {http.StatusTooManyRequests, "Too many requests"},
+	codeInternal:           {http.StatusInternalServerError, "Internal server error"},
+}
+
+// appError is a failure to report to the client. detail and fields are safe
+// to show; cause is only logged.
+type appError struct {
+	code   errorCode
+	detail string
+	fields validate.Errors
+	cause  error
+}
+
+func newAppError(code errorCode, detail string, cause error) *appError {
+	return &appError{code: code, detail: detail, cause: cause}
+}
+
+func (e *appError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.code, e.detail, e.cause)
+	}
+	return fmt.Sprintf("%s: %s", e.code, e.detail)
+}
+
+func (e *appError) Unwrap() error {
+	return e.cause
+}
+
+func errMalformedBody(cause error) *appError {
+	return newAppError(codeMalformedBody, "Request body must be a valid JSON object.", cause)
+}
+
+// errValidation reports fields that failed validation. The field errors are
+// also the cause, so they show up in the access log.
+func errValidation(fields validate.Errors) *appError {
+	return &appError{
+		code:   codeValidationFailed,
+		detail: "One or more fields are invalid.",
+		fields: fields,
+		cause:  fields,
+	}
+}
+
+func errInvalidUUID(param string, cause error) *appError {
+	return newAppError(codeInvalidParameter, param+" must be a UUID.", cause)
+}
+
+// toAppError maps err to the appError it should be reported as. Errors the
+// handlers did not classify themselves are matched against the store's
+// error conditions; anything else is internal and its message withheld.
+func toAppError(err error) *appError {
+	var appErr *appError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return newAppError(codeNotFound, "The requested resource does not exist.", err)
+	case errors.Is(err, bcrypt.ErrPasswordTooLong):
+		return newAppError(codeValidationFailed, "Password must be at most 72 bytes.", err)
+	default:
+		return newAppError(codeInternal, "Something went wrong on our side.", err)
+	}
+}
+
+// problem is an RFC 7807 problem details object.
+type problem struct {
+	Type      string    `json:"type"`
+	Title     string    `json:"title"`
+	Status    int       `json:"status"`
+	Detail    string    `json:"detail,omitempty"`
+	Instance  string    `json:"instance,omitempty"`
+	Code      errorCode `json:"code"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Errors lists the offending fields of a validation_failed problem.
+	Errors validate.Errors `json:"errors,omitempty"`
+}
+
+// problemTypeURI identifies a kind of problem. They are URNs rather than
+// links because there is no page to dereference.
+func problemTypeURI(code errorCode) string {
+	return "urn:chirpy:problem:" + string(code)
+}
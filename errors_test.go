@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/05blue04/chirpy/internal/database"
+	"github.com/05blue04/chirpy/internal/store"
+	"github.com/google/uuid"
+)
+
+func TestToAppError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want errorCode
+	}{
+		{"app error", newAppError(codeForbidden, "no", nil), codeForbidden},
+		{"wrapped app error", fmt.Errorf("handler: %w", newAppError(codeNotFound, "gone", nil)), codeNotFound},
+		// Only the user handlers know a unique violation means the email
+		// is taken; anywhere else it is a bug.
+		{"unique violation", fmt.Errorf("create refresh token: %w", store.ErrUniqueViolation), codeInternal},
+		{"unclassified", errors.New("connection refused"), codeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toAppError(tt.err).code; got != tt.want {
+				t.Errorf("toAppError(%v).code = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProblemTypesComplete(t *testing.T) {
+	codes := []errorCode{
+		codeMalformedBody, codeInvalidParameter, codeValidationFailed,
+		codeUnauthenticated, codeInvalidCredentials, codeInvalidToken,
+		codeForbidden, codeNotFound, codeEmailTaken, codeRateLimited, codeInternal,
+	}
+	for _, code := range codes {
+		if pt, ok := problemTypes[code]; !ok || pt.status == 0 || pt.title == "" {
+			t.Errorf("problemTypes[%q] = %+v, %v", code, pt, ok)
+		}
+	}
+}
+
+func TestRespondWithErrorHidesCause(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	respondWithError(rec, req, errors.New("pq: password authentication failed for user chirpy"))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "password authentication") {
+		t.Errorf("response leaks the internal cause: %s", rec.Body)
+	}
+	var p problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != codeInternal || p.Status != http.StatusInternalServerError {
+		t.Errorf("problem = %+v", p)
+	}
+}
+
+// failingStore is a store whose chirp lookups fail as they would with the
+// database down.
+type failingStore struct {
+	store.Store
+}
+
+func (failingStore) GetChirpById(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	return database.Chirp{}, errors.New("dial tcp 127.0.0.1:5432: connect: connection refused")
+}
+
+func TestChirpLookupFailureIsInternal(t *testing.T) {
+	c := newTestClient(t, newTestConfig(t, failingStore{store.NewMemory()}, nil, 0))
+	p := c.expectProblem(c.do("GET", "/api/v1/chirps/"+uuid.NewString(), "", nil), codeInternal)
+	if strings.Contains(p.Detail, "connection refused") {
+		t.Errorf("problem leaks the internal cause: %+v", p)
+	}
+}
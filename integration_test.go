@@ -0,0 +1,888 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/config"
+	"github.com/05blue04/chirpy/internal/mail"
+	"github.com/05blue04/chirpy/internal/migrate"
+	"github.com/05blue04/chirpy/internal/pubsub"
+	"github.com/05blue04/chirpy/internal/store"
+	"github.com/google/uuid"
+)
+
+const (
+	testSecret   = "0123456789abcdef0123456789abcdef"
+	testPolkaKey = "polka-test-key"
+)
+
+func TestMain(m *testing.M) {
+	// The access log would otherwise print a line for every request.
+	slog.SetDefault(slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testBackends lists the stores the integration suite runs against. Postgres
+// is included when CHIRPY_TEST_POSTGRES_URL points at a throwaway database.
+func testBackends() map[string]func(t *testing.T) *apiConfig {
+	backends := map[string]func(t *testing.T) *apiConfig{
+		"memory": func(t *testing.T) *apiConfig {
+			return newTestConfig(t, store.NewMemory(), nil, 0)
+		},
+		"sqlite": func(t *testing.T) *apiConfig {
+			return openTestConfig(t, "sqlite:"+filepath.Join(t.TempDir(), "chirpy.db"))
+		},
+	}
+	if dbURL := os.Getenv("CHIRPY_TEST_POSTGRES_URL"); dbURL != "" {
+		backends["postgres"] = func(t *testing.T) *apiConfig {
+			cfg := openTestConfig(t, dbURL)
+			if err := cfg.db.ClearUsers(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			return cfg
+		}
+	}
+	return backends
+}
+
+func openTestConfig(t *testing.T, dbURL string) *apiConfig {
+	t.Helper()
+	conn, err := store.Open(dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.DB.Close() })
+
+	m, err := migrate.New(conn.DB, conn.Backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	schema, err := migrate.Latest(conn.Backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestConfig(t, conn.Store, conn.DB, schema)
+}
+
+func newTestConfig(t *testing.T, db store.Store, conn *sql.DB, schema int64) *apiConfig {
+	conf := config.Default()
+	conf.Platform = "dev"
+	conf.JWTSecret = testSecret
+	conf.PolkaKey = testPolkaKey
+	// Scenarios sign up and log in more often than the default policies
+	// allow; testRateLimits turns limiting on for itself.
+	conf.RateLimit.Enabled = false
+
+	workers := newWorkerGroup()
+	t.Cleanup(func() { workers.Shutdown(context.Background()) })
+
+	queue, err := newJobQueue(conf.Jobs, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := pubsub.NewHub(conf.Stream.ReplaySize)
+	return &apiConfig{
+		conf:     conf,
+		db:       db,
+		conn:     conn,
+		schema:   schema,
+		metrics:  newAppMetrics(conn),
+		workers:  workers,
+		hub:      hub,
+		bus:      pubsub.NewMemory(hub),
+		jobs:     queue,
+		mailer:   mail.NewMemory(),
+		platform: conf.Platform,
+		secret:   conf.JWTSecret,
+		apiKey:   conf.PolkaKey,
+	}
+}
+
+// testClient talks to the full handler stack served by httptest.
+type testClient struct {
+	t   *testing.T
+	srv *httptest.Server
+}
+
+func newTestClient(t *testing.T, cfg *apiConfig) *testClient {
+	srv := httptest.NewServer(cfg.routes())
+	t.Cleanup(srv.Close)
+	return &testClient{t: t, srv: srv}
+}
+
+type testResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// do sends body as JSON (unless it is nil) with auth as the Authorization
+// header (unless it is empty).
+func (c *testClient) do(method, path, auth string, body any) testResponse {
+	c.t.Helper()
+	if body == nil {
+		return c.send(method, path, auth, "", nil)
+	}
+	dat, err := json.Marshal(body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return c.send(method, path, auth, "application/json", dat)
+}
+
+// send sends body verbatim, with contentType and auth as headers unless they
+// are empty.
+func (c *testClient) send(method, path, auth, contentType string, body []byte) testResponse {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.srv.URL+path, bytes.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	return c.roundTrip(req)
+}
+
+// get sends a GET with the given request headers.
+func (c *testClient) get(path string, header http.Header) testResponse {
+	c.t.Helper()
+	req, err := http.NewRequest("GET", c.srv.URL+path, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header = header
+	return c.roundTrip(req)
+}
+
+func (c *testClient) roundTrip(req *http.Request) testResponse {
+	c.t.Helper()
+	resp, err := c.srv.Client().Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+	dat, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return testResponse{status: resp.StatusCode, header: resp.Header, body: dat}
+}
+
+// expect fails the test unless the response has the given status, then
+// decodes the body into v when v is non-nil.
+func (c *testClient) expect(resp testResponse, status int, v any) {
+	c.t.Helper()
+	if resp.status != status {
+		c.t.Fatalf("status = %d, want %d; body: %s", resp.status, status, resp.body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(resp.body, v); err != nil {
+			c.t.Fatalf("decoding %s: %v", resp.body, err)
+		}
+	}
+}
+
+// expectProblem fails the test unless the response is an RFC 7807 problem
+// with the given code and the status that code maps to.
+func (c *testClient) expectProblem(resp testResponse, code errorCode) problem {
+	c.t.Helper()
+	var p problem
+	c.expect(resp, problemTypes[code].status, &p)
+	if ct := resp.header.Get("Content-Type"); ct != "application/problem+json" {
+		c.t.Errorf("Content-Type = %q, want application/problem+json", ct)
+	}
+	if p.Code != code || p.Type != problemTypeURI(code) {
+		c.t.Errorf("problem code = %q type = %q, want %q", p.Code, p.Type, code)
+	}
+	if p.RequestID == "" || p.RequestID != resp.header.Get("X-Request-ID") {
+		c.t.Errorf("problem request_id = %q, want the X-Request-ID header %q", p.RequestID, resp.header.Get("X-Request-ID"))
+	}
+	if p.Instance != "urn:chirpy:request:"+p.RequestID {
+		c.t.Errorf("problem instance = %q", p.Instance)
+	}
+	return p
+}
+
+type testSession struct {
+	User
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (c *testClient) signup(email, password string) User {
+	c.t.Helper()
+	var u User
+	c.expect(c.do("POST", "/api/v1/users", "", map[string]string{"email": email, "password": password}), http.StatusCreated, &u)
+	return u
+}
+
+func (c *testClient) login(email, password string) testSession {
+	c.t.Helper()
+	var s testSession
+	c.expect(c.do("POST", "/api/v1/login", "", map[string]string{"email": email, "password": password}), http.StatusOK, &s)
+	return s
+}
+
+func (c *testClient) createChirp(token, body string) Chirp {
+	c.t.Helper()
+	var chirp Chirp
+	c.expect(c.do("POST", "/api/v1/chirps", bearer(token), map[string]string{"body": body}), http.StatusCreated, &chirp)
+	return chirp
+}
+
+// sseEvent is one event read from a text/event-stream response. comment is
+// set for heartbeats.
+type sseEvent struct {
+	id, event, data string
+	comment         bool
+}
+
+// stream opens an event stream. Its events arrive on the returned channel,
+// which is closed when the stream ends.
+func (c *testClient) stream(path string, header http.Header) <-chan sseEvent {
+	c.t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	c.t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, "GET", c.srv.URL+path, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if header != nil {
+		req.Header = header
+	}
+	resp, err := c.srv.Client().Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		resp.Body.Close()
+		c.t.Fatalf("stream status = %d, Content-Type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan sseEvent)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		sc := bufio.NewScanner(resp.Body)
+		var e sseEvent
+		for sc.Scan() {
+			line := sc.Text()
+			if line != "" {
+				if strings.HasPrefix(line, ":") {
+					e.comment = true
+				}
+				field, value, _ := strings.Cut(line, ": ")
+				switch field {
+				case "id":
+					e.id = value
+				case "event":
+					e.event = value
+				case "data":
+					e.data = value
+				}
+				continue
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+			e = sseEvent{}
+		}
+	}()
+	return events
+}
+
+// next returns the next event from a stream, skipping heartbeats.
+func (c *testClient) next(events <-chan sseEvent) sseEvent {
+	c.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				c.t.Fatal("stream ended")
+			}
+			if !e.comment {
+				return e
+			}
+		case <-timeout:
+			c.t.Fatal("timed out waiting for a stream event")
+		}
+	}
+}
+
+// with returns a client that reports failures to t, for use inside
+// subtests.
+func (c *testClient) with(t *testing.T) *testClient {
+	return &testClient{t: t, srv: c.srv}
+}
+
+func bearer(token string) string {
+	return "Bearer " + token
+}
+
+func TestIntegration(t *testing.T) {
+	scenarios := []struct {
+		name string
+		run  func(t *testing.T, c *testClient)
+	}{
+		{"SignupAndLogin", testSignupAndLogin},
+		{"UpdateUser", testUpdateUser},
+		{"RefreshAndRevoke", testRefreshAndRevoke},
+		{"ChirpCRUD", testChirpCRUD},
+		{"ChirpFilters", testChirpFilters},
+		{"ConcurrentDelete", testConcurrentDelete},
+		{"AuthorizationFailures", testAuthorizationFailures},
+		{"RequestValidation", testRequestValidation},
+		{"PolkaWebhooks", testPolkaWebhooks},
+		{"Reset", testReset},
+		{"Probes", testProbes},
+		{"LegacyAliases", testLegacyAliases},
+		{"ConditionalGets", testConditionalGets},
+		{"ChirpStream", testChirpStream},
+	}
+
+	for name, newConfig := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			for _, sc := range scenarios {
+				t.Run(sc.name, func(t *testing.T) {
+					sc.run(t, newTestClient(t, newConfig(t)))
+				})
+			}
+		})
+	}
+}
+
+func testSignupAndLogin(t *testing.T, c *testClient) {
+	u := c.signup("walt@example.com", "04234")
+	if u.ID == uuid.Nil || u.Email != "walt@example.com" || u.Is_chirpy_red {
+		t.Errorf("signup returned %+v", u)
+	}
+
+	// Emails are unique.
+	resp := c.do("POST", "/api/v1/users", "", map[string]string{"email": "walt@example.com", "password": "other"})
+	c.expectProblem(resp, codeEmailTaken)
+
+	s := c.login("walt@example.com", "04234")
+	if s.ID != u.ID || s.Token == "" || s.RefreshToken == "" {
+		t.Errorf("login returned %+v", s)
+	}
+
+	tests := []struct {
+		name string
+		body any
+		code errorCode
+	}{
+		{"wrong password", map[string]string{"email": "walt@example.com", "password": "wrong"}, codeInvalidCredentials},
+		{"unknown email", map[string]string{"email": "jesse@example.com", "password": "04234"}, codeInvalidCredentials},
+		{"malformed body", "not an object", codeMalformedBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := c.with(t)
+			c.expectProblem(c.do("POST", "/api/v1/login", "", tt.body), tt.code)
+		})
+	}
+}
+
+func testUpdateUser(t *testing.T, c *testClient) {
+	u := c.signup("walt@example.com", "04234")
+	s := c.login("walt@example.com", "04234")
+
+	var updated User
+	resp := c.do("PUT", "/api/v1/users", bearer(s.Token), map[string]string{"email": "heisenberg@example.com", "password": "blue"})
+	c.expect(resp, http.StatusOK, &updated)
+	if updated.ID != u.ID || updated.Email != "heisenberg@example.com" {
+		t.Errorf("update returned %+v", updated)
+	}
+
+	c.login("heisenberg@example.com", "blue")
+	c.expectProblem(c.do("POST", "/api/v1/login", "", map[string]string{"email": "walt@example.com", "password": "04234"}), codeInvalidCredentials)
+
+	// Another account's email is taken.
+	c.signup("jesse@example.com", "pinkman")
+	resp = c.do("PUT", "/api/v1/users", bearer(s.Token), map[string]string{"email": "jesse@example.com", "password": "blue"})
+	c.expectProblem(resp, codeEmailTaken)
+}
+
+func testRefreshAndRevoke(t *testing.T, c *testClient) {
+	c.signup("walt@example.com", "04234")
+	s := c.login("walt@example.com", "04234")
+
+	var refreshed struct {
+		Token string `json:"token"`
+	}
+	c.expect(c.do("POST", "/api/v1/refresh", bearer(s.RefreshToken), nil), http.StatusOK, &refreshed)
+	if refreshed.Token == "" {
+		t.Fatal("refresh returned no token")
+	}
+	// The new access token works.
+	c.createChirp(refreshed.Token, "refreshed")
+
+	// An access token is not a refresh token.
+	c.expectProblem(c.do("POST", "/api/v1/refresh", bearer(s.Token), nil), codeInvalidToken)
+	c.expectProblem(c.do("POST", "/api/v1/refresh", "", nil), codeUnauthenticated)
+
+	c.expect(c.do("POST", "/api/v1/revoke", bearer(s.RefreshToken), nil), http.StatusNoContent, nil)
+	c.expectProblem(c.do("POST", "/api/v1/refresh", bearer(s.RefreshToken), nil), codeInvalidToken)
+	c.expectProblem(c.do("POST", "/api/v1/revoke", "", nil), codeUnauthenticated)
+}
+
+func testChirpCRUD(t *testing.T, c *testClient) {
+	u := c.signup("walt@example.com", "04234")
+	s := c.login("walt@example.com", "04234")
+
+	chirp := c.createChirp(s.Token, "I'm the one who knocks!")
+	if chirp.UserID != u.ID || chirp.Body != "I'm the one who knocks!" {
+		t.Errorf("create returned %+v", chirp)
+	}
+
+	var got Chirp
+	c.expect(c.do("GET", "/api/v1/chirps/"+chirp.ID.String(), "", nil), http.StatusOK, &got)
+	if got.ID != chirp.ID || got.Body != chirp.Body {
+		t.Errorf("get returned %+v, want %+v", got, chirp)
+	}
+
+	cleaned := c.createChirp(s.Token, "what a Kerfuffle this is")
+	if cleaned.Body != "what a **** this is" {
+		t.Errorf("profane chirp body = %q", cleaned.Body)
+	}
+
+	long := string(bytes.Repeat([]byte("a"), 141))
+	c.expectProblem(c.do("POST", "/api/v1/chirps", bearer(s.Token), map[string]string{"body": long}), codeValidationFailed)
+
+	c.expect(c.do("DELETE", "/api/v1/chirps/"+chirp.ID.String(), bearer(s.Token), nil), http.StatusNoContent, nil)
+	c.expectProblem(c.do("GET", "/api/v1/chirps/"+chirp.ID.String(), "", nil), codeNotFound)
+	c.expectProblem(c.do("DELETE", "/api/v1/chirps/"+chirp.ID.String(), bearer(s.Token), nil), codeNotFound)
+
+	c.expectProblem(c.do("GET", "/api/v1/chirps/not-a-uuid", "", nil), codeInvalidParameter)
+	c.expectProblem(c.do("GET", "/api/v1/chirps/"+uuid.NewString(), "", nil), codeNotFound)
+}
+
+func testChirpFilters(t *testing.T, c *testClient) {
+	walt := c.signup("walt@example.com", "04234")
+	c.signup("jesse@example.com", "yo")
+	ws := c.login("walt@example.com", "04234")
+	js := c.login("jesse@example.com", "yo")
+
+	first := c.createChirp(ws.Token, "first")
+	c.createChirp(js.Token, "second")
+	last := c.createChirp(ws.Token, "third")
+
+	var all []Chirp
+	c.expect(c.do("GET", "/api/v1/chirps", "", nil), http.StatusOK, &all)
+	if len(all) != 3 || all[0].ID != first.ID {
+		t.Errorf("GET /api/chirps returned %+v, want 3 chirps oldest first", all)
+	}
+
+	var desc []Chirp
+	c.expect(c.do("GET", "/api/v1/chirps?sort=desc", "", nil), http.StatusOK, &desc)
+	if len(desc) != 3 || desc[0].ID != last.ID {
+		t.Errorf("GET /api/chirps?sort=desc returned %+v, want newest first", desc)
+	}
+
+	var byWalt []Chirp
+	c.expect(c.do("GET", "/api/v1/chirps?author_id="+walt.ID.String(), "", nil), http.StatusOK, &byWalt)
+	if len(byWalt) != 2 {
+		t.Fatalf("author filter returned %d chirps, want 2", len(byWalt))
+	}
+	for _, chirp := range byWalt {
+		if chirp.UserID != walt.ID {
+			t.Errorf("author filter returned chirp by %s", chirp.UserID)
+		}
+	}
+
+	c.expectProblem(c.do("GET", "/api/v1/chirps?author_id=nope", "", nil), codeInvalidParameter)
+}
+
+func testConcurrentDelete(t *testing.T, c *testClient) {
+	c.signup("walt@example.com", "04234")
+	s := c.login("walt@example.com", "04234")
+	chirp := c.createChirp(s.Token, "only once")
+
+	const n = 8
+	statuses := make(chan int, n)
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("DELETE", c.srv.URL+"/api/v1/chirps/"+chirp.ID.String(), nil)
+			req.Header.Set("Authorization", bearer(s.Token))
+			resp, err := c.srv.Client().Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusNoContent] != 1 || counts[http.StatusNotFound] != n-1 {
+		t.Errorf("concurrent deletes returned %v, want one 204 and the rest 404", counts)
+	}
+}
+
+func testAuthorizationFailures(t *testing.T, c *testClient) {
+	c.signup("walt@example.com", "04234")
+	c.signup("jesse@example.com", "yo")
+	ws := c.login("walt@example.com", "04234")
+	js := c.login("jesse@example.com", "yo")
+	chirp := c.createChirp(ws.Token, "mine")
+	chirpPath := "/api/v1/chirps/" + chirp.ID.String()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		body   any
+		code   errorCode
+	}{
+		{"create without token", "POST", "/api/v1/chirps", "", map[string]string{"body": "hi"}, codeUnauthenticated},
+		{"create with garbage token", "POST", "/api/v1/chirps", bearer("garbage"), map[string]string{"body": "hi"}, codeInvalidToken},
+		{"create with refresh token", "POST", "/api/v1/chirps", bearer(ws.RefreshToken), map[string]string{"body": "hi"}, codeInvalidToken},
+		{"update without token", "PUT", "/api/v1/users", "", map[string]string{"email": "x@example.com", "password": "x"}, codeUnauthenticated},
+		{"update to taken email", "PUT", "/api/v1/users", bearer(js.Token), map[string]string{"email": "walt@example.com", "password": "x"}, codeEmailTaken},
+		{"delete without token", "DELETE", chirpPath, "", nil, codeUnauthenticated},
+		{"delete someone else's chirp", "DELETE", chirpPath, bearer(js.Token), nil, codeForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := c.with(t)
+			c.expectProblem(c.do(tt.method, tt.path, tt.auth, tt.body), tt.code)
+		})
+	}
+
+	// The chirp survived the failed delete.
+	c.expect(c.do("GET", chirpPath, "", nil), http.StatusOK, nil)
+}
+
+func testRequestValidation(t *testing.T, c *testClient) {
+	u := c.signup("walt@example.com", "04234")
+	s := c.login("walt@example.com", "04234")
+	polkaAuth := "ApiKey " + testPolkaKey
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		auth        string
+		contentType string
+		body        string
+		code        errorCode
+		fields      []string
+	}{
+		{"missing content type", "POST", "/api/v1/users", "", "", `{"email":"a@example.com","password":"pw"}`, codeUnsupportedMedia, nil},
+		{"form content type", "POST", "/api/v1/login", "", "application/x-www-form-urlencoded", "email=a@example.com", codeUnsupportedMedia, nil},
+		{"too large", "POST", "/api/v1/chirps", bearer(s.Token), "application/json", `{"body":"` + strings.Repeat("a", 2<<20) + `"}`, codeBodyTooLarge, nil},
+		{"empty body", "POST", "/api/v1/users", "", "application/json", "", codeMalformedBody, nil},
+		{"syntax error", "POST", "/api/v1/users", "", "application/json", `{"email":`, codeMalformedBody, nil},
+		{"trailing data", "POST", "/api/v1/users", "", "application/json", `{"email":"a@example.com","password":"pw"} {}`, codeMalformedBody, nil},
+		{"unknown field", "POST", "/api/v1/users", "", "application/json", `{"email":"a@example.com","password":"pw","admin":true}`, codeValidationFailed, []string{"admin"}},
+		{"wrong type", "POST", "/api/v1/chirps", bearer(s.Token), "application/json", `{"body":5}`, codeValidationFailed, []string{"body"}},
+		{"missing fields", "POST", "/api/v1/users", "", "application/json", `{}`, codeValidationFailed, []string{"password", "email"}},
+		{"invalid email", "PUT", "/api/v1/users", bearer(s.Token), "application/json", `{"email":"walt","password":"pw"}`, codeValidationFailed, []string{"email"}},
+		{"empty chirp", "POST", "/api/v1/chirps", bearer(s.Token), "application/json", `{"body":""}`, codeValidationFailed, []string{"body"}},
+		{"polka user id not a uuid", "POST", "/api/v1/polka/webhooks", polkaAuth, "application/json", `{"event":"user.upgraded","data":{"user_id":"walt"}}`, codeValidationFailed, []string{"data.user_id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := c.with(t)
+			p := c.expectProblem(c.send(tt.method, tt.path, tt.auth, tt.contentType, []byte(tt.body)), tt.code)
+			var fields []string
+			for _, fe := range p.Errors {
+				fields = append(fields, fe.Field)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("problem fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+
+	// Webhook senders may add fields we do not know about.
+	body := `{"event":"user.upgraded","data":{"user_id":"` + u.ID.String() + `","plan":"red"},"sent_at":"now"}`
+	c.expect(c.send("POST", "/api/v1/polka/webhooks", polkaAuth, "application/json; charset=utf-8", []byte(body)), http.StatusNoContent, nil)
+}
+
+func testPolkaWebhooks(t *testing.T, c *testClient) {
+	u := c.signup("walt@example.com", "04234")
+
+	event := func(name string, userID uuid.UUID) map[string]any {
+		return map[string]any{
+			"event": name,
+			"data":  map[string]string{"user_id": userID.String()},
+		}
+	}
+
+	tests := []struct {
+		name string
+		auth string
+		body any
+		code errorCode
+	}{
+		{"missing key", "", event("user.upgraded", u.ID), codeUnauthenticated},
+		{"wrong key", "ApiKey wrong", event("user.upgraded", u.ID), codeUnauthenticated},
+		{"bearer instead of api key", bearer(testPolkaKey), event("user.upgraded", u.ID), codeUnauthenticated},
+		{"malformed body", "ApiKey " + testPolkaKey, "nope", codeMalformedBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := c.with(t)
+			c.expectProblem(c.do("POST", "/api/v1/polka/webhooks", tt.auth, tt.body), tt.code)
+		})
+	}
+
+	// Events other than user.upgraded are acknowledged and ignored, whatever
+	// data they carry; Polka retries anything else.
+	c.expect(c.do("POST", "/api/v1/polka/webhooks", "ApiKey "+testPolkaKey, event("user.payment_failed", u.ID)), http.StatusNoContent, nil)
+	c.expect(c.do("POST", "/api/v1/polka/webhooks", "ApiKey "+testPolkaKey, map[string]any{"event": "user.payment_failed"}), http.StatusNoContent, nil)
+	c.expect(c.do("POST", "/api/v1/polka/webhooks", "ApiKey "+testPolkaKey, map[string]any{}), http.StatusNoContent, nil)
+
+	// user.upgraded needs a user to upgrade.
+	c.expectProblem(c.do("POST", "/api/v1/polka/webhooks", "ApiKey "+testPolkaKey, map[string]any{"event": "user.upgraded"}), codeValidationFailed)
+	c.expectProblem(c.do("POST", "/api/v1/polka/webhooks", "ApiKey "+testPolkaKey, map[string]any{
+		"event": "user.upgraded",
+		"data":  map[string]string{"user_id": "not-a-uuid"},
+	}), codeValidationFailed)
+
+	if s := c.login("walt@example.com", "04234"); s.Is_chirpy_red {
+		t.Fatal("user upgraded before a valid user.upgraded event")
+	}
+
+	c.expect(c.do("POST", "/api/v1/polka/webhooks", "ApiKey "+testPolkaKey, event("user.upgraded", u.ID)), http.StatusNoContent, nil)
+	if s := c.login("walt@example.com", "04234"); !s.Is_chirpy_red {
+		t.Error("user not upgraded after user.upgraded event")
+	}
+}
+
+func testReset(t *testing.T, c *testClient) {
+	c.signup("walt@example.com", "04234")
+	s := c.login("walt@example.com", "04234")
+	c.createChirp(s.Token, "gone soon")
+
+	c.expect(c.do("POST", "/admin/reset", "", nil), http.StatusOK, nil)
+
+	var chirps []Chirp
+	c.expect(c.do("GET", "/api/v1/chirps", "", nil), http.StatusOK, &chirps)
+	if len(chirps) != 0 {
+		t.Errorf("%d chirps left after reset", len(chirps))
+	}
+	c.expectProblem(c.do("POST", "/api/v1/login", "", map[string]string{"email": "walt@example.com", "password": "04234"}), codeInvalidCredentials)
+	// The email is free again.
+	c.signup("walt@example.com", "04234")
+}
+
+func testProbes(t *testing.T, c *testClient) {
+	c.expect(c.do("GET", "/api/livez", "", nil), http.StatusOK, nil)
+	c.expect(c.do("GET", "/api/healthz", "", nil), http.StatusOK, nil)
+
+	var ready struct {
+		Status string `json:"status"`
+	}
+	c.expect(c.do("GET", "/api/readyz", "", nil), http.StatusOK, &ready)
+	if ready.Status != "ok" {
+		t.Errorf("readyz status = %q, want ok", ready.Status)
+	}
+
+	resp := c.do("GET", "/api/livez", "", nil)
+	if resp.header.Get("X-Request-ID") == "" {
+		t.Error("response has no X-Request-ID header")
+	}
+}
+
+func testLegacyAliases(t *testing.T, c *testClient) {
+	// Clients of the unversioned API keep working and are told where to go.
+	u := c.signup("walt@example.com", "04234")
+	s := c.login("walt@example.com", "04234")
+	chirp := c.createChirp(s.Token, "still here")
+
+	resp := c.do("GET", "/api/chirps/"+chirp.ID.String(), "", nil)
+	var got Chirp
+	c.expect(resp, http.StatusOK, &got)
+	if got.ID != chirp.ID {
+		t.Errorf("legacy GET returned chirp %s, want %s", got.ID, chirp.ID)
+	}
+	if dep := resp.header.Get("Deprecation"); !strings.HasPrefix(dep, "@") {
+		t.Errorf("Deprecation = %q, want @<unix time>", dep)
+	}
+	if resp.header.Get("Sunset") == "" {
+		t.Error("legacy response has no Sunset header")
+	}
+	wantLink := "</api/v1/chirps/" + chirp.ID.String() + `>; rel="successor-version"`
+	if link := resp.header.Get("Link"); link != wantLink {
+		t.Errorf("Link = %q, want %q", link, wantLink)
+	}
+
+	resp = c.do("POST", "/api/login", "", map[string]string{"email": u.Email, "password": "04234"})
+	c.expect(resp, http.StatusOK, nil)
+	if resp.header.Get("Deprecation") == "" {
+		t.Error("legacy login has no Deprecation header")
+	}
+
+	resp = c.do("GET", "/api/v1/chirps", "", nil)
+	c.expect(resp, http.StatusOK, nil)
+	if resp.header.Get("Deprecation") != "" {
+		t.Error("versioned route is marked deprecated")
+	}
+}
+
+func testConditionalGets(t *testing.T, c *testClient) {
+	c.signup("walt@example.com", "04234")
+	s := c.login("walt@example.com", "04234")
+	first := c.createChirp(s.Token, "first")
+	second := c.createChirp(s.Token, "second")
+
+	path := "/api/v1/chirps/" + first.ID.String()
+	resp := c.do("GET", path, "", nil)
+	c.expect(resp, http.StatusOK, nil)
+	etag, lastModified := resp.header.Get("ETag"), resp.header.Get("Last-Modified")
+	if !strings.HasPrefix(etag, `"`) {
+		t.Fatalf("ETag = %q, want a strong validator", etag)
+	}
+	if lastModified == "" || resp.header.Get("Cache-Control") != chirpCacheControl {
+		t.Errorf("Last-Modified = %q, Cache-Control = %q", lastModified, resp.header.Get("Cache-Control"))
+	}
+
+	for name, header := range map[string]http.Header{
+		"If-None-Match":      {"If-None-Match": {`"other", ` + etag}},
+		"weak If-None-Match": {"If-None-Match": {"W/" + etag}},
+		"If-Modified-Since":  {"If-Modified-Since": {lastModified}},
+	} {
+		resp := c.get(path, header)
+		if resp.status != http.StatusNotModified || len(resp.body) != 0 {
+			t.Errorf("%s: status = %d with %d byte body, want an empty 304", name, resp.status, len(resp.body))
+		}
+		if resp.header.Get("ETag") != etag {
+			t.Errorf("%s: 304 ETag = %q, want %q", name, resp.header.Get("ETag"), etag)
+		}
+	}
+	// If-None-Match wins over If-Modified-Since.
+	resp = c.get(path, http.Header{"If-None-Match": {`"stale"`}, "If-Modified-Since": {lastModified}})
+	c.expect(resp, http.StatusOK, nil)
+
+	list := func(query string) string {
+		resp := c.do("GET", "/api/v1/chirps"+query, "", nil)
+		c.expect(resp, http.StatusOK, nil)
+		if resp.header.Get("Last-Modified") != "" {
+			t.Error("chirp list sent Last-Modified, which deletions would not advance")
+		}
+		return resp.header.Get("ETag")
+	}
+	asc := list("")
+	if resp := c.get("/api/v1/chirps", http.Header{"If-None-Match": {asc}}); resp.status != http.StatusNotModified {
+		t.Errorf("unchanged list status = %d, want 304", resp.status)
+	}
+	if list("?sort=desc") == asc {
+		t.Error("descending list has the same ETag as ascending")
+	}
+
+	c.expect(c.do("DELETE", "/api/v1/chirps/"+second.ID.String(), bearer(s.Token), nil), http.StatusNoContent, nil)
+	afterDelete := list("")
+	if afterDelete == asc {
+		t.Error("list ETag unchanged after a delete")
+	}
+	c.createChirp(s.Token, "third")
+	if list("") == afterDelete {
+		t.Error("list ETag unchanged after a create")
+	}
+
+	resp = c.do("GET", "/app/assets/logo.png", "", nil)
+	c.expect(resp, http.StatusOK, nil)
+	if cc := resp.header.Get("Cache-Control"); cc != assetCacheControl {
+		t.Errorf("asset Cache-Control = %q, want %q", cc, assetCacheControl)
+	}
+	resp = c.do("GET", "/app/", "", nil)
+	c.expect(resp, http.StatusOK, nil)
+	if cc := resp.header.Get("Cache-Control"); cc != pageCacheControl {
+		t.Errorf("page Cache-Control = %q, want %q", cc, pageCacheControl)
+	}
+	if resp := c.do("GET", "/app/missing.html", "", nil); resp.header.Get("Cache-Control") != "" {
+		t.Error("404 from the file server is cacheable")
+	}
+}
+
+func testChirpStream(t *testing.T, c *testClient) {
+	walt := c.signup("walt@example.com", "04234")
+	c.signup("jesse@example.com", "abq")
+	ws := c.login("walt@example.com", "04234")
+	js := c.login("jesse@example.com", "abq")
+
+	all := c.stream("/api/v1/stream/chirps", nil)
+	onlyWalt := c.stream("/api/v1/stream/chirps?author_id="+walt.ID.String(), nil)
+
+	jesses := c.createChirp(js.Token, "yo")
+	walts := c.createChirp(ws.Token, "say my name")
+	c.expect(c.do("DELETE", "/api/v1/chirps/"+walts.ID.String(), bearer(ws.Token), nil), http.StatusNoContent, nil)
+
+	expectCreated := func(e sseEvent, want Chirp) {
+		t.Helper()
+		var got Chirp
+		if err := json.Unmarshal([]byte(e.data), &got); err != nil {
+			t.Fatalf("decoding %q: %v", e.data, err)
+		}
+		if e.event != eventChirpCreated || got.ID != want.ID || got.Body != want.Body || e.id == "" {
+			t.Errorf("event %q %+v, want %s of %+v", e.event, got, eventChirpCreated, want)
+		}
+	}
+	expectDeleted := func(e sseEvent, want Chirp) {
+		t.Helper()
+		var got chirpDeletedEvent
+		if err := json.Unmarshal([]byte(e.data), &got); err != nil {
+			t.Fatalf("decoding %q: %v", e.data, err)
+		}
+		if e.event != eventChirpDeleted || got.ID != want.ID || got.UserID != want.UserID {
+			t.Errorf("event %q %+v, want %s of %v", e.event, got, eventChirpDeleted, want.ID)
+		}
+	}
+
+	first := c.next(all)
+	expectCreated(first, jesses)
+	expectCreated(c.next(all), walts)
+	expectDeleted(c.next(all), walts)
+
+	expectCreated(c.next(onlyWalt), walts)
+	expectDeleted(c.next(onlyWalt), walts)
+
+	// Reconnecting replays what came after the last event seen.
+	resumed := c.stream("/api/v1/stream/chirps", http.Header{"Last-Event-ID": {first.id}})
+	expectCreated(c.next(resumed), walts)
+	expectDeleted(c.next(resumed), walts)
+
+	stale := c.stream("/api/stream/chirps?last_event_id=unknown-1", nil)
+	if e := c.next(stale); e.event != "reset" || e.id == "" {
+		t.Errorf("resuming from an unknown ID got %+v, want a reset", e)
+	}
+
+	c.expectProblem(c.get("/api/v1/stream/chirps?author_id=walt", nil), codeInvalidParameter)
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
@@ -11,12 +12,29 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var tracer = otel.Tracer("github.com/05blue04/chirpy/internal/auth")
+
 func HashPassword(password string) (string, error) {
+	return HashPasswordContext(context.Background(), password)
+}
+
+// HashPasswordContext is HashPassword with the bcrypt work recorded as a
+// span under ctx.
+func HashPasswordContext(ctx context.Context, password string) (string, error) {
+	_, span := tracer.Start(ctx, "bcrypt.GenerateFromPassword",
+		trace.WithAttributes(attribute.Int("bcrypt.cost", bcrypt.DefaultCost)))
+	defer span.End()
+
 	psswrd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return "", err
 	}
 
@@ -24,8 +42,18 @@ func HashPassword(password string) (string, error) {
 }
 
 func CheckPasswordHash(password, hash string) error {
+	return CheckPasswordHashContext(context.Background(), password, hash)
+}
+
+// CheckPasswordHashContext is CheckPasswordHash with the bcrypt work
+// recorded as a span under ctx.
+func CheckPasswordHashContext(ctx context.Context, password, hash string) error {
+	_, span := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 
@@ -86,6 +114,64 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 
 }
 
+// JWTExpiry returns when a token expires. It does not verify the token, so
+// call it only on one ValidateJWT has accepted.
+func JWTExpiry(tokenString string) (time.Time, error) {
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, &claims); err != nil {
+		return time.Time{}, err
+	}
+	if claims.ExpiresAt == nil {
+		return time.Time{}, errors.New("token has no expiry")
+	}
+	return claims.ExpiresAt.Time, nil
+}
+
+// emailIssuer marks email verification tokens. ValidateJWT only accepts the
+// "chirpy" issuer, so a verification link can never be used to log in, and
+// ValidateEmailToken turns access tokens away the same way.
+const emailIssuer = "chirpy-email"
+
+type emailClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// MakeEmailToken signs a token proving that whoever holds it received mail
+// sent to email on behalf of userID.
+func MakeEmailToken(userID uuid.UUID, email, tokenSecret string, expiresIn time.Duration) (string, error) {
+	claims := &emailClaims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			Issuer:    emailIssuer,
+			Subject:   userID.String(),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(tokenSecret))
+}
+
+// ValidateEmailToken checks a token made by MakeEmailToken and returns the
+// user and address it was issued for.
+func ValidateEmailToken(tokenString, tokenSecret string) (uuid.UUID, string, error) {
+	claims := emailClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
+		return []byte(tokenSecret), nil
+	}, jwt.WithIssuer(emailIssuer), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}), jwt.WithExpirationRequired())
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, "", fmt.Errorf("invalid user ID: %w", err)
+	}
+	if claims.Email == "" {
+		return uuid.Nil, "", errors.New("token has no email")
+	}
+	return userID, claims.Email, nil
+}
+
 func GetBearerToken(headers http.Header) (string, error) {
 	bearerToken := headers.Get("Authorization")
 
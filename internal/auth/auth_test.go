@@ -110,6 +110,51 @@ func TestValidateJWT(t *testing.T) {
 	}
 }
 
+func TestValidateEmailToken(t *testing.T) {
+	userID := uuid.New()
+	token, err := MakeEmailToken(userID, "a@example.com", "secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotID, gotEmail, err := ValidateEmailToken(token, "secret")
+	if err != nil || gotID != userID || gotEmail != "a@example.com" {
+		t.Errorf("ValidateEmailToken() = %v, %q, %v", gotID, gotEmail, err)
+	}
+
+	if _, _, err := ValidateEmailToken(token, "wrong_secret"); err == nil {
+		t.Error("accepted a token signed with another secret")
+	}
+	expired, _ := MakeEmailToken(userID, "a@example.com", "secret", -time.Minute)
+	if _, _, err := ValidateEmailToken(expired, "secret"); err == nil {
+		t.Error("accepted an expired token")
+	}
+	// Neither kind of token stands in for the other.
+	if _, err := ValidateJWT(token, "secret"); err == nil {
+		t.Error("ValidateJWT accepted an email token")
+	}
+	access, _ := MakeJWT(userID, "secret", time.Hour)
+	if _, _, err := ValidateEmailToken(access, "secret"); err == nil {
+		t.Error("ValidateEmailToken accepted an access token")
+	}
+}
+
+func TestJWTExpiry(t *testing.T) {
+	before := time.Now().Add(time.Hour).Truncate(time.Second)
+	token, _ := MakeJWT(uuid.New(), "secret", time.Hour)
+
+	exp, err := JWTExpiry(token)
+	if err != nil {
+		t.Fatalf("JWTExpiry() error = %v", err)
+	}
+	if exp.Before(before) || exp.After(time.Now().Add(time.Hour)) {
+		t.Errorf("JWTExpiry() = %v, want about an hour from now", exp)
+	}
+
+	if _, err := JWTExpiry("invalid.token.string"); err == nil {
+		t.Error("JWTExpiry() accepted a malformed token")
+	}
+}
+
 func TestGetBearerToken(t *testing.T) {
 	header := http.Header{}
 	header.Add("Authorization", "Bearer eyxtoken")
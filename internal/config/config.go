@@ -0,0 +1,701 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/mail"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Platforms accepted for PLATFORM. Only "dev" enables destructive admin
+// endpoints.
+var Platforms = []string{"dev", "prod"}
+
+// MinSecretLength is the shortest JWT_SECRET accepted, in bytes. HS256 keys
+// shorter than the hash output weaken the signature.
+const MinSecretLength = 32
+
+const redacted = "REDACTED"
+
+type Config struct {
+	Port      int    `json:"port"`
+	DBURL     string `json:"db_url"`
+	Platform  string `json:"platform"`
+	JWTSecret string `json:"jwt_secret"`
+	PolkaKey  string `json:"polka_key"`
+	LogLevel  string `json:"log_level"`
+	// AutoMigrate applies pending migrations before the server starts.
+	AutoMigrate bool        `json:"auto_migrate"`
+	Server      Server      `json:"server"`
+	RateLimit   RateLimit   `json:"rate_limit"`
+	CORS        CORS        `json:"cors"`
+	Security    Security    `json:"security"`
+	Compression Compression `json:"compression"`
+	Stream      Stream      `json:"stream"`
+	Jobs        Jobs        `json:"jobs"`
+	TokenSweep  TokenSweep  `json:"token_sweep"`
+	Mail        Mail        `json:"mail"`
+}
+
+type Server struct {
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	ReadTimeout       Duration `json:"read_timeout"`
+	WriteTimeout      Duration `json:"write_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout"`
+	ShutdownTimeout   Duration `json:"shutdown_timeout"`
+	MaxHeaderBytes    int      `json:"max_header_bytes"`
+	MaxBodyBytes      int      `json:"max_body_bytes"`
+}
+
+// CORS controls which other origins' web pages may call the API. It is off
+// while AllowedOrigins is empty.
+type CORS struct {
+	// AllowedOrigins are exact origins such as https://chirpy.example, or
+	// "*" for any.
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge Duration `json:"max_age"`
+}
+
+// Security holds the security headers sent with every response.
+type Security struct {
+	ContentSecurityPolicy string `json:"content_security_policy"`
+	// HSTSMaxAge is sent in Strict-Transport-Security on HTTPS requests;
+	// zero disables the header.
+	HSTSMaxAge Duration `json:"hsts_max_age"`
+	// TrustForwardedProto treats requests a proxy marks with
+	// X-Forwarded-Proto: https as HTTPS. Only enable it behind a proxy
+	// that sets the header.
+	TrustForwardedProto bool `json:"trust_forwarded_proto"`
+}
+
+// Compression controls gzip, brotli and zstd encoding of responses.
+type Compression struct {
+	Enabled bool `json:"enabled"`
+	// MinSize is the smallest response body, in bytes, worth compressing.
+	MinSize int `json:"min_size"`
+}
+
+// StreamBuses are the accepted values for STREAM_BUS. The postgres bus
+// delivers events to clients on every replica and needs a Postgres DB_URL.
+var StreamBuses = []string{"memory", "postgres"}
+
+// Stream controls live events: the server-sent chirp stream and the
+// WebSocket API.
+type Stream struct {
+	// Bus is how events reach the other replicas' clients.
+	Bus string `json:"bus"`
+	// ReplaySize is how many recent events are kept for clients that
+	// reconnect with Last-Event-ID.
+	ReplaySize int `json:"replay_size"`
+	// Heartbeat is how often an idle stream gets a comment, which keeps
+	// proxies from closing it.
+	Heartbeat Duration `json:"heartbeat"`
+}
+
+// JobStores are the accepted values for JOBS_STORE. The postgres store keeps
+// jobs across restarts, shares them between replicas and needs a Postgres
+// DB_URL.
+var JobStores = []string{"memory", "postgres"}
+
+// Jobs controls the background job queue.
+type Jobs struct {
+	Store string `json:"store"`
+	// Concurrency is how many jobs each instance runs at once.
+	Concurrency int `json:"concurrency"`
+	// PollInterval is how often idle workers look for due jobs.
+	PollInterval Duration `json:"poll_interval"`
+	// MaxAttempts is how often a failing job is tried before it is
+	// dead-lettered.
+	MaxAttempts int `json:"max_attempts"`
+	// Timeout bounds a single attempt at a job.
+	Timeout Duration `json:"timeout"`
+	// Retention is how long finished and dead-lettered jobs are kept.
+	Retention Duration `json:"retention"`
+}
+
+// TokenSweep controls the job that deletes stale refresh tokens.
+type TokenSweep struct {
+	// Schedule is a cron spec, or a descriptor such as @hourly.
+	Schedule string `json:"schedule"`
+	// RevokedRetention is how long revoked tokens are kept before they are
+	// deleted. Expired tokens are deleted on the next sweep.
+	RevokedRetention Duration `json:"revoked_retention"`
+	// BatchSize caps the rows each delete statement removes, so no single
+	// statement holds locks on the table for long.
+	BatchSize int `json:"batch_size"`
+}
+
+// MailTransports are the accepted values for MAIL_TRANSPORT. log writes
+// messages to stderr, or MAIL_LOG_FILE, for development; smtp sends them.
+var MailTransports = []string{"log", "smtp"}
+
+// Mail controls the email the server sends.
+type Mail struct {
+	Transport string `json:"transport"`
+	// From is the sender, such as "Chirpy <noreply@chirpy.example>".
+	From string `json:"from"`
+	// LogFile is where the log transport appends messages. Empty means
+	// stderr.
+	LogFile string `json:"log_file"`
+	// VerifyURL is the page address verification links open. It gets the
+	// token as its token query parameter and should POST it to
+	// /api/v1/users/verify.
+	VerifyURL string `json:"verify_url"`
+	// VerifyTTL is how long a verification link works.
+	VerifyTTL Duration `json:"verify_ttl"`
+	SMTP      SMTP     `json:"smtp"`
+}
+
+// SMTP is the mail server the smtp transport sends through.
+type SMTP struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// Username and Password are sent with PLAIN auth. An empty Username
+	// skips authentication.
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RateLimitStores are the accepted values for RATE_LIMIT_STORE. The
+// postgres store shares buckets between replicas and needs a Postgres
+// DB_URL.
+var RateLimitStores = []string{"memory", "postgres"}
+
+type RateLimit struct {
+	Enabled bool   `json:"enabled"`
+	Store   string `json:"store"`
+	// TrustForwardedFor keys anonymous clients by the last address in
+	// X-Forwarded-For. Only enable it behind a proxy that sets the header.
+	TrustForwardedFor bool `json:"trust_forwarded_for"`
+	// Policies are referred to by name from the route table. A config file
+	// can replace any of them.
+	Policies map[string]RateLimitPolicy `json:"policies"`
+}
+
+// RateLimitPolicy allows Burst requests at once, refilling at Limit
+// requests per Period.
+type RateLimitPolicy struct {
+	Limit  int      `json:"limit"`
+	Period Duration `json:"period"`
+	Burst  int      `json:"burst"`
+}
+
+func Default() Config {
+	return Config{
+		Port:     8080,
+		Platform: "prod",
+		LogLevel: "info",
+		Server: Server{
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(15 * time.Second),
+			WriteTimeout:      Duration(30 * time.Second),
+			IdleTimeout:       Duration(120 * time.Second),
+			ShutdownTimeout:   Duration(30 * time.Second),
+			MaxHeaderBytes:    1 << 20,
+			MaxBodyBytes:      1 << 20,
+		},
+		RateLimit: RateLimit{
+			Enabled: true,
+			Store:   "memory",
+			Policies: map[string]RateLimitPolicy{
+				"signup": {Limit: 5, Period: Duration(time.Hour), Burst: 5},
+				"auth":   {Limit: 10, Period: Duration(time.Minute), Burst: 10},
+				"write":  {Limit: 30, Period: Duration(time.Minute), Burst: 10},
+				"read":   {Limit: 300, Period: Duration(time.Minute), Burst: 100},
+			},
+		},
+		CORS: CORS{
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "X-Request-ID", "If-None-Match", "If-Modified-Since"},
+			ExposedHeaders: []string{
+				"X-Request-ID", "ETag", "Retry-After", "Deprecation", "Sunset", "Link",
+				"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+			},
+			MaxAge: Duration(10 * time.Minute),
+		},
+		Security: Security{
+			ContentSecurityPolicy: "default-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'",
+			HSTSMaxAge:            Duration(180 * 24 * time.Hour),
+		},
+		Compression: Compression{
+			Enabled: true,
+			MinSize: 1024,
+		},
+		Stream: Stream{
+			Bus:        "memory",
+			ReplaySize: 256,
+			Heartbeat:  Duration(15 * time.Second),
+		},
+		Jobs: Jobs{
+			Store:        "memory",
+			Concurrency:  4,
+			PollInterval: Duration(time.Second),
+			MaxAttempts:  5,
+			Timeout:      Duration(5 * time.Minute),
+			Retention:    Duration(7 * 24 * time.Hour),
+		},
+		TokenSweep: TokenSweep{
+			Schedule:         "@hourly",
+			RevokedRetention: Duration(30 * 24 * time.Hour),
+			BatchSize:        1000,
+		},
+		Mail: Mail{
+			Transport: "log",
+			From:      "Chirpy <noreply@localhost>",
+			VerifyURL: "http://localhost:8080/app/verify.html",
+			VerifyTTL: Duration(48 * time.Hour),
+			SMTP:      SMTP{Port: 587},
+		},
+	}
+}
+
+// Load parses the configuration and validates all of it.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	c, err := Parse(args, getenv)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Parse builds the configuration by layering, lowest precedence first:
+// defaults, the JSON file named by -config or CHIRPY_CONFIG (optional),
+// environment variables, then command-line flags. It does not validate the
+// result; commands that need only part of it validate that part themselves.
+//
+// Secrets are deliberately not accepted as flags so they don't show up in
+// process listings.
+func Parse(args []string, getenv func(string) string) (Config, error) {
+	c := Default()
+	var path string
+
+	newFlagSet := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+		fs.StringVar(&path, "config", getenv("CHIRPY_CONFIG"), "path to a JSON config file")
+		fs.IntVar(&c.Port, "port", c.Port, "port to listen on")
+		fs.StringVar(&c.DBURL, "db-url", c.DBURL, "database connection URL")
+		fs.StringVar(&c.Platform, "platform", c.Platform, "deployment platform (dev or prod)")
+		fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "minimum log level (debug, info, warn, error)")
+		fs.BoolVar(&c.AutoMigrate, "auto-migrate", c.AutoMigrate, "apply pending migrations on start")
+		fs.Var(&c.Server.ReadHeaderTimeout, "read-header-timeout", "time allowed to read request headers")
+		fs.Var(&c.Server.ReadTimeout, "read-timeout", "time allowed to read a whole request")
+		fs.Var(&c.Server.WriteTimeout, "write-timeout", "time allowed to write a response")
+		fs.Var(&c.Server.IdleTimeout, "idle-timeout", "keep-alive idle timeout")
+		fs.Var(&c.Server.ShutdownTimeout, "shutdown-timeout", "time allowed for graceful shutdown")
+		fs.IntVar(&c.Server.MaxHeaderBytes, "max-header-bytes", c.Server.MaxHeaderBytes, "maximum request header size")
+		fs.IntVar(&c.Server.MaxBodyBytes, "max-body-bytes", c.Server.MaxBodyBytes, "maximum JSON request body size")
+		fs.BoolVar(&c.RateLimit.Enabled, "rate-limit", c.RateLimit.Enabled, "enforce rate limits")
+		fs.StringVar(&c.RateLimit.Store, "rate-limit-store", c.RateLimit.Store, "where rate limit buckets are kept (memory or postgres)")
+		fs.Var((*List)(&c.CORS.AllowedOrigins), "cors-allowed-origins", "comma-separated origins allowed to call the API, or *")
+		fs.BoolVar(&c.CORS.AllowCredentials, "cors-allow-credentials", c.CORS.AllowCredentials, "let cross-origin requests send credentials")
+		fs.Var(&c.Security.HSTSMaxAge, "hsts-max-age", "Strict-Transport-Security max-age on HTTPS requests (0 disables)")
+		fs.BoolVar(&c.Compression.Enabled, "compression", c.Compression.Enabled, "compress responses the client accepts encoded")
+		fs.StringVar(&c.Jobs.Store, "jobs-store", c.Jobs.Store, "where background jobs are kept (memory or postgres)")
+		fs.IntVar(&c.Jobs.Concurrency, "jobs-concurrency", c.Jobs.Concurrency, "background jobs run at once")
+		fs.StringVar(&c.Mail.Transport, "mail-transport", c.Mail.Transport, "how email is sent (log or smtp)")
+		fs.StringVar(&c.Stream.Bus, "stream-bus", c.Stream.Bus, "how live events reach other replicas (memory or postgres)")
+		return fs
+	}
+
+	// The first parse only tells us where the config file is; the flags are
+	// parsed again once the file and environment have been applied so that
+	// they take precedence.
+	if err := newFlagSet().Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if path != "" {
+		if err := loadFile(&c, path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := loadEnv(&c, getenv); err != nil {
+		return Config{}, err
+	}
+
+	fs := newFlagSet()
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+func loadFile(c *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+func loadEnv(c *Config, getenv func(string) string) error {
+	var errs []error
+	str := func(key string, dst *string) {
+		if v := getenv(key); v != "" {
+			*dst = v
+		}
+	}
+	num := func(key string, dst *int) {
+		if v := getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("config: %s: %q is not an integer", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if v := getenv(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("config: %s: %q is not a boolean", key, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if v := getenv(key); v != "" {
+			(*List)(dst).Set(v)
+		}
+	}
+	dur := func(key string, dst *Duration) {
+		if v := getenv(key); v != "" {
+			if err := dst.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("config: %s: %w", key, err))
+			}
+		}
+	}
+
+	num("PORT", &c.Port)
+	str("DB_URL", &c.DBURL)
+	str("PLATFORM", &c.Platform)
+	str("JWT_SECRET", &c.JWTSecret)
+	str("POLKA_KEY", &c.PolkaKey)
+	str("LOG_LEVEL", &c.LogLevel)
+	boolean("AUTO_MIGRATE", &c.AutoMigrate)
+	dur("SERVER_READ_HEADER_TIMEOUT", &c.Server.ReadHeaderTimeout)
+	dur("SERVER_READ_TIMEOUT", &c.Server.ReadTimeout)
+	dur("SERVER_WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	dur("SERVER_IDLE_TIMEOUT", &c.Server.IdleTimeout)
+	dur("SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+	num("SERVER_MAX_HEADER_BYTES", &c.Server.MaxHeaderBytes)
+	num("SERVER_MAX_BODY_BYTES", &c.Server.MaxBodyBytes)
+	boolean("RATE_LIMIT_ENABLED", &c.RateLimit.Enabled)
+	str("RATE_LIMIT_STORE", &c.RateLimit.Store)
+	boolean("RATE_LIMIT_TRUST_FORWARDED_FOR", &c.RateLimit.TrustForwardedFor)
+	list("CORS_ALLOWED_ORIGINS", &c.CORS.AllowedOrigins)
+	list("CORS_ALLOWED_METHODS", &c.CORS.AllowedMethods)
+	list("CORS_ALLOWED_HEADERS", &c.CORS.AllowedHeaders)
+	boolean("CORS_ALLOW_CREDENTIALS", &c.CORS.AllowCredentials)
+	dur("CORS_MAX_AGE", &c.CORS.MaxAge)
+	str("SECURITY_CSP", &c.Security.ContentSecurityPolicy)
+	dur("SECURITY_HSTS_MAX_AGE", &c.Security.HSTSMaxAge)
+	boolean("SECURITY_TRUST_FORWARDED_PROTO", &c.Security.TrustForwardedProto)
+	boolean("COMPRESSION_ENABLED", &c.Compression.Enabled)
+	num("COMPRESSION_MIN_SIZE", &c.Compression.MinSize)
+	str("STREAM_BUS", &c.Stream.Bus)
+	num("STREAM_REPLAY_SIZE", &c.Stream.ReplaySize)
+	dur("STREAM_HEARTBEAT", &c.Stream.Heartbeat)
+	str("JOBS_STORE", &c.Jobs.Store)
+	num("JOBS_CONCURRENCY", &c.Jobs.Concurrency)
+	dur("JOBS_POLL_INTERVAL", &c.Jobs.PollInterval)
+	num("JOBS_MAX_ATTEMPTS", &c.Jobs.MaxAttempts)
+	dur("JOBS_TIMEOUT", &c.Jobs.Timeout)
+	dur("JOBS_RETENTION", &c.Jobs.Retention)
+	str("TOKEN_SWEEP_SCHEDULE", &c.TokenSweep.Schedule)
+	dur("TOKEN_SWEEP_REVOKED_RETENTION", &c.TokenSweep.RevokedRetention)
+	num("TOKEN_SWEEP_BATCH_SIZE", &c.TokenSweep.BatchSize)
+	str("MAIL_TRANSPORT", &c.Mail.Transport)
+	str("MAIL_FROM", &c.Mail.From)
+	str("MAIL_LOG_FILE", &c.Mail.LogFile)
+	str("MAIL_VERIFY_URL", &c.Mail.VerifyURL)
+	dur("MAIL_VERIFY_TTL", &c.Mail.VerifyTTL)
+	str("SMTP_HOST", &c.Mail.SMTP.Host)
+	num("SMTP_PORT", &c.Mail.SMTP.Port)
+	str("SMTP_USERNAME", &c.Mail.SMTP.Username)
+	str("SMTP_PASSWORD", &c.Mail.SMTP.Password)
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every problem with c at once so a misconfigured deploy
+// can be fixed in one go.
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("config: "+format, args...))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		fail("PORT must be between 1 and 65535, got %d", c.Port)
+	}
+
+	if err := c.ValidateDatabase(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if !slices.Contains(Platforms, c.Platform) {
+		fail("PLATFORM must be one of %v, got %q", Platforms, c.Platform)
+	}
+
+	if len(c.JWTSecret) < MinSecretLength {
+		fail("JWT_SECRET must be at least %d bytes, got %d", MinSecretLength, len(c.JWTSecret))
+	}
+
+	if c.PolkaKey == "" {
+		fail("POLKA_KEY is required")
+	}
+
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		fail("LOG_LEVEL must be one of debug, info, warn, error, got %q", c.LogLevel)
+	}
+
+	if c.Server.ReadHeaderTimeout <= 0 {
+		fail("SERVER_READ_HEADER_TIMEOUT must be positive")
+	}
+	for _, t := range []struct {
+		name string
+		d    Duration
+	}{
+		{"SERVER_READ_TIMEOUT", c.Server.ReadTimeout},
+		{"SERVER_WRITE_TIMEOUT", c.Server.WriteTimeout},
+		{"SERVER_IDLE_TIMEOUT", c.Server.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", c.Server.ShutdownTimeout},
+	} {
+		if t.d < 0 {
+			fail("%s must not be negative", t.name)
+		}
+	}
+	if c.Server.MaxHeaderBytes <= 0 {
+		fail("SERVER_MAX_HEADER_BYTES must be positive")
+	}
+	if c.Server.MaxBodyBytes <= 0 {
+		fail("SERVER_MAX_BODY_BYTES must be positive")
+	}
+
+	if !slices.Contains(RateLimitStores, c.RateLimit.Store) {
+		fail("RATE_LIMIT_STORE must be one of %v, got %q", RateLimitStores, c.RateLimit.Store)
+	}
+	if c.RateLimit.Enabled && c.RateLimit.Store == "postgres" {
+		if u, err := url.Parse(c.DBURL); err == nil && u.Scheme == "sqlite" {
+			fail("RATE_LIMIT_STORE postgres needs a Postgres DB_URL")
+		}
+	}
+	if c.CORS.AllowCredentials && slices.Contains(c.CORS.AllowedOrigins, "*") {
+		fail("CORS_ALLOW_CREDENTIALS cannot be used with CORS_ALLOWED_ORIGINS *; list the origins")
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if u, err := url.Parse(origin); origin != "*" && (err != nil || u.Scheme == "" || u.Host == "" || u.Path != "") {
+			fail("CORS_ALLOWED_ORIGINS entry %q must be * or a scheme and host such as https://chirpy.example", origin)
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		fail("CORS_MAX_AGE must not be negative")
+	}
+	if c.Security.HSTSMaxAge < 0 {
+		fail("SECURITY_HSTS_MAX_AGE must not be negative")
+	}
+	if c.Compression.MinSize < 0 {
+		fail("COMPRESSION_MIN_SIZE must not be negative")
+	}
+	if !slices.Contains(StreamBuses, c.Stream.Bus) {
+		fail("STREAM_BUS must be one of %v, got %q", StreamBuses, c.Stream.Bus)
+	}
+	if c.Stream.Bus == "postgres" {
+		if u, err := url.Parse(c.DBURL); err == nil && u.Scheme == "sqlite" {
+			fail("STREAM_BUS postgres needs a Postgres DB_URL")
+		}
+	}
+	if c.Stream.ReplaySize < 0 {
+		fail("STREAM_REPLAY_SIZE must not be negative")
+	}
+	if c.Stream.Heartbeat <= 0 {
+		fail("STREAM_HEARTBEAT must be positive")
+	}
+	if !slices.Contains(JobStores, c.Jobs.Store) {
+		fail("JOBS_STORE must be one of %v, got %q", JobStores, c.Jobs.Store)
+	}
+	if c.Jobs.Store == "postgres" {
+		if u, err := url.Parse(c.DBURL); err == nil && u.Scheme == "sqlite" {
+			fail("JOBS_STORE postgres needs a Postgres DB_URL")
+		}
+	}
+	if c.Jobs.Concurrency <= 0 {
+		fail("JOBS_CONCURRENCY must be positive")
+	}
+	if c.Jobs.MaxAttempts <= 0 {
+		fail("JOBS_MAX_ATTEMPTS must be positive")
+	}
+	for _, t := range []struct {
+		name string
+		d    Duration
+	}{
+		{"JOBS_POLL_INTERVAL", c.Jobs.PollInterval},
+		{"JOBS_TIMEOUT", c.Jobs.Timeout},
+		{"JOBS_RETENTION", c.Jobs.Retention},
+	} {
+		if t.d <= 0 {
+			fail("%s must be positive", t.name)
+		}
+	}
+	if _, err := cron.ParseStandard(c.TokenSweep.Schedule); err != nil {
+		fail("TOKEN_SWEEP_SCHEDULE: %v", err)
+	}
+	if c.TokenSweep.RevokedRetention < 0 {
+		fail("TOKEN_SWEEP_REVOKED_RETENTION must not be negative")
+	}
+	if c.TokenSweep.BatchSize <= 0 {
+		fail("TOKEN_SWEEP_BATCH_SIZE must be positive")
+	}
+	if !slices.Contains(MailTransports, c.Mail.Transport) {
+		fail("MAIL_TRANSPORT must be one of %v, got %q", MailTransports, c.Mail.Transport)
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		fail("MAIL_FROM must be an email address: %v", err)
+	}
+	if u, err := url.Parse(c.Mail.VerifyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fail("MAIL_VERIFY_URL must be an absolute http or https URL, got %q", c.Mail.VerifyURL)
+	}
+	if c.Mail.VerifyTTL <= 0 {
+		fail("MAIL_VERIFY_TTL must be positive")
+	}
+	if c.Mail.Transport == "smtp" {
+		if c.Mail.SMTP.Host == "" {
+			fail("MAIL_TRANSPORT smtp needs SMTP_HOST")
+		}
+		if c.Mail.SMTP.Port < 1 || c.Mail.SMTP.Port > 65535 {
+			fail("SMTP_PORT must be between 1 and 65535, got %d", c.Mail.SMTP.Port)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.RateLimit.Policies)) {
+		p := c.RateLimit.Policies[name]
+		if p.Limit <= 0 || p.Period <= 0 || p.Burst <= 0 {
+			fail("rate limit policy %q needs a positive limit, period and burst", name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateDatabase checks only DB_URL, for commands such as migrate that
+// need nothing else.
+func (c Config) ValidateDatabase() error {
+	if c.DBURL == "" {
+		return errors.New("config: DB_URL is required")
+	}
+	u, err := url.Parse(c.DBURL)
+	if err != nil {
+		return errors.New("config: DB_URL is not a valid URL")
+	}
+	switch u.Scheme {
+	case "postgres", "postgresql", "sqlite":
+		return nil
+	default:
+		return fmt.Errorf("config: DB_URL must use the postgres:// or sqlite: scheme, got %q", u.Scheme)
+	}
+}
+
+// Redacted returns a copy of c that is safe to log or display: secrets are
+// masked and the database password is removed from DB_URL.
+func (c Config) Redacted() Config {
+	if c.JWTSecret != "" {
+		c.JWTSecret = redacted
+	}
+	if c.PolkaKey != "" {
+		c.PolkaKey = redacted
+	}
+	if c.Mail.SMTP.Password != "" {
+		c.Mail.SMTP.Password = redacted
+	}
+	if u, err := url.Parse(c.DBURL); err == nil {
+		q := u.Query()
+		if q.Has("password") {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+		c.DBURL = u.Redacted()
+	} else if c.DBURL != "" {
+		c.DBURL = redacted
+	}
+	return c
+}
+
+// Duration is a time.Duration that reads and writes Go duration strings
+// such as "15s" in JSON and flags.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"15s\"")
+	}
+	return d.Set(s)
+}
+
+// List is a comma-separated list of strings in flags and environment
+// variables, and a JSON array in the config file.
+type List []string
+
+func (l List) String() string {
+	return strings.Join(l, ",")
+}
+
+func (l *List) Set(s string) error {
+	*l = nil
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
@@ -72,6 +72,23 @@ func (q *Queries) GetChirpById(ctx context.Context, id uuid.UUID) (Chirp, error)
 	return i, err
 }
 
+const getChirpByIdForUpdate = `-- name: GetChirpByIdForUpdate :one
+SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) GetChirpByIdForUpdate(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpByIdForUpdate, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+	)
+	return i, err
+}
+
 const getChirps = `-- name: GetChirps :many
 SELECT id, created_at, updated_at, body, user_id FROM chirps ORDER BY created_at ASC
 `
@@ -39,6 +39,34 @@ func (q *Queries) CreateToken(ctx context.Context, arg CreateTokenParams) error
 	return err
 }
 
+const deleteStaleTokens = `-- name: DeleteStaleTokens :execrows
+DELETE FROM refresh_tokens
+WHERE token IN (
+    SELECT token FROM refresh_tokens
+    WHERE expires_at < $1
+       OR revoked_at < $2::timestamp
+    LIMIT $3
+    FOR UPDATE SKIP LOCKED
+)
+`
+
+type DeleteStaleTokensParams struct {
+	ExpiredBefore time.Time
+	RevokedBefore time.Time
+	BatchSize     int32
+}
+
+// Deletes up to batch_size tokens that expired before expired_before or were
+// revoked before revoked_before. Rows another transaction holds are left for
+// the next batch.
+func (q *Queries) DeleteStaleTokens(ctx context.Context, arg DeleteStaleTokensParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteStaleTokens, arg.ExpiredBefore, arg.RevokedBefore, arg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const getTokenByID = `-- name: GetTokenByID :one
 SELECT token, created_at, updated_at, user_id, expires_at, revoked_at FROM refresh_tokens 
 WHERE token = $1 AND revoked_at IS NULL
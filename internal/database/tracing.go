@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/05blue04/chirpy/internal/database"
+
+// TracedDB is a DBTX that records a client span around every statement it
+// runs. Spans are named after the sqlc query, e.g. "GetChirps".
+type TracedDB struct {
+	db     DBTX
+	tracer trace.Tracer
+	system string
+}
+
+func NewTracedDB(db DBTX, system string) *TracedDB {
+	return &TracedDB{
+		db:     db,
+		tracer: otel.Tracer(tracerName),
+		system: system,
+	}
+}
+
+func (t *TracedDB) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, queryName(query),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", t.system),
+			attribute.String("db.query.text", query),
+		),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *TracedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := t.start(ctx, query)
+	res, err := t.db.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return res, err
+}
+
+func (t *TracedDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, span := t.start(ctx, query)
+	stmt, err := t.db.PrepareContext(ctx, query)
+	endSpan(span, err)
+	return stmt, err
+}
+
+func (t *TracedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := t.start(ctx, query)
+	rows, err := t.db.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (t *TracedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := t.start(ctx, query)
+	row := t.db.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+// queryName extracts the name from sqlc's "-- name: Foo :one" header,
+// falling back to the leading SQL keyword.
+func queryName(query string) string {
+	if rest, ok := strings.CutPrefix(query, "-- name: "); ok {
+		if name, _, ok := strings.Cut(rest, " "); ok {
+			return name
+		}
+	}
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "query"
+	}
+	return strings.ToUpper(fields[0])
+}
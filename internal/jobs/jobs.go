@@ -0,0 +1,372 @@
+// Package jobs runs work outside request handlers.
+//
+// A job is a kind, which names the Handler that runs it, and a JSON
+// payload. Queue claims due jobs from a Store with a pool of workers, retries
+// failures with exponential backoff and dead-letters a job once it has used
+// up its attempts. Schedules enqueue jobs on cron specs. Jobs live in a
+// Store: Memory for a single instance, Postgres to make them durable and
+// share them between replicas.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Job states.
+const (
+	// Pending jobs run once RunAt has passed.
+	Pending = "pending"
+	// Running jobs are held by a worker until their lease expires.
+	Running = "running"
+	Done    = "done"
+	// Dead jobs failed every attempt, or could not be run at all. They are
+	// kept for inspection until pruned.
+	Dead = "dead"
+)
+
+// States lists every job state.
+var States = []string{Pending, Running, Done, Dead}
+
+// Job is a unit of work in the queue.
+type Job struct {
+	ID   int64  `json:"id"`
+	Kind string `json:"kind"`
+	// Payload is left out of JSON. Jobs are listed on an admin endpoint,
+	// and a payload may carry personal data or secrets.
+	Payload json.RawMessage `json:"-"`
+	State   string          `json:"state"`
+	// Attempts counts the times the job has been claimed, including the
+	// current one while it is running.
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"`
+	RunAt       time.Time `json:"run_at"`
+	LastError   string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// NewJob describes a job to enqueue.
+type NewJob struct {
+	Kind        string
+	Payload     json.RawMessage
+	RunAt       time.Time
+	MaxAttempts int
+	// Key, if set, makes the job unique: enqueueing another job with the
+	// same key does nothing.
+	Key string
+}
+
+// Store holds jobs. The attempt passed to Complete, Retry and DeadLetter is
+// the job's Attempts when it was claimed; if the job has been claimed again
+// since, because the lease expired, the call does nothing.
+type Store interface {
+	// Enqueue adds a job, reporting false if its key is already taken.
+	Enqueue(ctx context.Context, j NewJob) (bool, error)
+	// Claim marks the next due job Running for lease and counts an
+	// attempt. A Running job whose lease has expired, because its worker
+	// died, is due again. It reports false if no job is due.
+	Claim(ctx context.Context, lease time.Duration) (Job, bool, error)
+	Complete(ctx context.Context, id int64, attempt int) error
+	// Retry makes the job Pending again, to run at runAt.
+	Retry(ctx context.Context, id int64, attempt int, runAt time.Time, reason string) error
+	DeadLetter(ctx context.Context, id int64, attempt int, reason string) error
+	// List returns up to limit jobs in state, or in any state if it is
+	// empty, newest first.
+	List(ctx context.Context, state string, limit int) ([]Job, error)
+	// Counts returns how many jobs are in each state.
+	Counts(ctx context.Context) (map[string]int, error)
+	// Prune deletes Done and Dead jobs last updated before cutoff.
+	Prune(ctx context.Context, cutoff time.Time) error
+}
+
+// Handler runs a job of one kind. An error makes the job retry, until it
+// runs out of attempts. The context is cancelled when the job times out or
+// the queue shuts down.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// Options configure a Queue.
+type Options struct {
+	// Concurrency is how many jobs run at once.
+	Concurrency int
+	// PollInterval is how often idle workers look for due jobs. Jobs
+	// enqueued by this process wake a worker at once.
+	PollInterval time.Duration
+	// MaxAttempts is how often a job is tried before it is dead-lettered.
+	MaxAttempts int
+	// Timeout bounds a single attempt.
+	Timeout time.Duration
+	// Retention is how long finished and dead jobs are kept.
+	Retention time.Duration
+}
+
+// Queue runs jobs from a Store.
+type Queue struct {
+	store     Store
+	opts      Options
+	handlers  map[string]Handler
+	schedules []schedule
+	wake      chan struct{}
+	// backoff is how long to wait before retrying after the given failed
+	// attempt.
+	backoff func(attempt int) time.Duration
+}
+
+type schedule struct {
+	name    string
+	spec    cron.Schedule
+	kind    string
+	payload json.RawMessage
+}
+
+// New returns a Queue that runs jobs from store.
+func New(store Store, opts Options) (*Queue, error) {
+	if opts.Concurrency <= 0 || opts.PollInterval <= 0 || opts.MaxAttempts <= 0 || opts.Timeout <= 0 || opts.Retention <= 0 {
+		return nil, errors.New("jobs: concurrency, poll interval, max attempts, timeout and retention must be positive")
+	}
+	return &Queue{
+		store:    store,
+		opts:     opts,
+		handlers: map[string]Handler{},
+		wake:     make(chan struct{}, 1),
+		backoff:  backoff,
+	}, nil
+}
+
+// maxBackoff caps the delay between attempts.
+const maxBackoff = time.Hour
+
+// backoff doubles from 30 seconds with each failed attempt, plus up to a
+// quarter again so that jobs failing together don't retry together.
+func backoff(attempt int) time.Duration {
+	d := maxBackoff
+	if attempt < 8 {
+		d = min(30*time.Second<<(attempt-1), maxBackoff)
+	}
+	return d + rand.N(d/4)
+}
+
+// Register sets the handler for jobs of kind. It must be called before Run.
+func (q *Queue) Register(kind string, h Handler) {
+	q.handlers[kind] = h
+}
+
+// Schedule enqueues a job of kind with payload at the times given by spec,
+// a standard five-field cron expression such as "*/10 * * * *" or a
+// descriptor such as "@hourly". Every replica runs the schedule, but each
+// occurrence is enqueued only once. It must be called before Run.
+func (q *Queue) Schedule(name, spec, kind string, payload any) error {
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("jobs: schedule %q: %w", name, err)
+	}
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("jobs: schedule %q: %w", name, err)
+	}
+	q.schedules = append(q.schedules, schedule{name: name, spec: sched, kind: kind, payload: dat})
+	return nil
+}
+
+// Enqueue adds a job of kind to run now.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload any) error {
+	return q.EnqueueAt(ctx, kind, payload, time.Time{})
+}
+
+// EnqueueAt adds a job of kind to run at runAt, or now if it is zero.
+func (q *Queue) EnqueueAt(ctx context.Context, kind string, payload any, runAt time.Time) error {
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return q.enqueue(ctx, NewJob{Kind: kind, Payload: dat, RunAt: runAt})
+}
+
+func (q *Queue) enqueue(ctx context.Context, j NewJob) error {
+	if j.RunAt.IsZero() {
+		j.RunAt = time.Now()
+	}
+	j.MaxAttempts = q.opts.MaxAttempts
+	if _, err := q.store.Enqueue(ctx, j); err != nil {
+		return err
+	}
+	if !j.RunAt.After(time.Now()) {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// List returns up to limit jobs in state, or in any state if it is empty,
+// newest first.
+func (q *Queue) List(ctx context.Context, state string, limit int) ([]Job, error) {
+	return q.store.List(ctx, state, limit)
+}
+
+// Counts returns how many jobs are in each state, including those with
+// none.
+func (q *Queue) Counts(ctx context.Context) (map[string]int, error) {
+	counts, err := q.store.Counts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range States {
+		if _, ok := counts[s]; !ok {
+			counts[s] = 0
+		}
+	}
+	return counts, nil
+}
+
+// Run works through jobs and keeps the schedules until ctx is cancelled,
+// then waits for running jobs to stop. A job interrupted by shutdown is
+// retried at once by the next worker to come along.
+func (q *Queue) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for range q.opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	for _, s := range q.schedules {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.runSchedule(ctx, s)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.prune(ctx)
+	}()
+	wg.Wait()
+	return nil
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		// The lease outlasts the timeout so that a live worker always
+		// records the outcome before another can claim the job.
+		j, ok, err := q.store.Claim(ctx, q.opts.Timeout+time.Minute)
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("job queue unavailable", "error", err)
+		}
+		if err != nil || !ok {
+			select {
+			case <-ctx.Done():
+			case <-q.wake:
+			case <-time.After(q.opts.PollInterval):
+			}
+			continue
+		}
+		q.process(ctx, j)
+	}
+}
+
+// process runs a claimed job and records the outcome.
+func (q *Queue) process(ctx context.Context, j Job) {
+	log := slog.With("job_id", j.ID, "kind", j.Kind, "attempt", j.Attempts)
+	h, ok := q.handlers[j.Kind]
+	var err error
+	switch {
+	case !ok:
+		err = fmt.Errorf("no handler for job kind %q", j.Kind)
+	case j.Attempts > j.MaxAttempts:
+		err = errors.New("worker stopped during the last attempt")
+	default:
+		start := time.Now()
+		err = q.call(ctx, h, j)
+		log = log.With("duration", time.Since(start))
+	}
+
+	// The outcome is recorded even when the queue is shutting down.
+	rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	switch {
+	case err == nil:
+		log.Info("job done")
+		err = q.store.Complete(rctx, j.ID, j.Attempts)
+	case ok && ctx.Err() != nil:
+		log.Info("job interrupted by shutdown", "error", err)
+		err = q.store.Retry(rctx, j.ID, j.Attempts, time.Now(), err.Error())
+	case ok && j.Attempts < j.MaxAttempts:
+		runAt := time.Now().Add(q.backoff(j.Attempts))
+		log.Warn("job failed; will retry", "error", err, "retry_at", runAt)
+		err = q.store.Retry(rctx, j.ID, j.Attempts, runAt, err.Error())
+	default:
+		log.Error("job dead-lettered", "error", err)
+		err = q.store.DeadLetter(rctx, j.ID, j.Attempts, err.Error())
+	}
+	if err != nil {
+		log.Error("Error recording job outcome", "error", err)
+	}
+}
+
+// call runs h with the job's timeout, turning a panic into an error.
+func (q *Queue) call(ctx context.Context, h Handler, j Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, q.opts.Timeout)
+	defer cancel()
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return h(ctx, j.Payload)
+}
+
+func (q *Queue) runSchedule(ctx context.Context, s schedule) {
+	for {
+		next := s.spec.Next(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		if err := q.enqueueOccurrence(ctx, s, next); err != nil && ctx.Err() == nil {
+			slog.Warn("Error enqueueing scheduled job", "schedule", s.name, "error", err)
+		}
+	}
+}
+
+// enqueueOccurrence enqueues the job s is due to run at t. The key names
+// the occurrence, so replicas that all reach it enqueue one job between
+// them.
+func (q *Queue) enqueueOccurrence(ctx context.Context, s schedule, t time.Time) error {
+	return q.enqueue(ctx, NewJob{
+		Kind:    s.kind,
+		Payload: s.payload,
+		RunAt:   t,
+		Key:     "cron:" + s.name + ":" + t.UTC().Format(time.RFC3339),
+	})
+}
+
+// pruneInterval is how often old jobs are deleted.
+const pruneInterval = time.Hour
+
+func (q *Queue) prune(ctx context.Context) {
+	t := time.NewTicker(pruneInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := q.store.Prune(ctx, time.Now().Add(-q.opts.Retention)); err != nil && ctx.Err() == nil {
+				slog.Warn("Error pruning old jobs", "error", err)
+			}
+		}
+	}
+}
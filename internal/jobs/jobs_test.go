@@ -0,0 +1,262 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/migrate"
+	"github.com/05blue04/chirpy/internal/store"
+)
+
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	now := time.Now()
+	enqueue := func(kind string, runAt time.Time, key string) bool {
+		t.Helper()
+		ok, err := s.Enqueue(ctx, NewJob{Kind: kind, Payload: json.RawMessage(`[1]`), RunAt: runAt, MaxAttempts: 3, Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	claim := func(lease time.Duration) (Job, bool) {
+		t.Helper()
+		j, ok, err := s.Claim(ctx, lease)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return j, ok
+	}
+
+	enqueue("first", now.Add(-time.Minute), "")
+	enqueue("later", now.Add(time.Hour), "")
+	if !enqueue("keyed", now.Add(-30*time.Second), "k") || enqueue("keyed", now.Add(-30*time.Second), "k") {
+		t.Fatal("a second job with the same key was enqueued")
+	}
+
+	first, ok := claim(time.Minute)
+	if !ok || first.Kind != "first" || first.State != Running || first.Attempts != 1 || string(first.Payload) != "[1]" {
+		t.Fatalf("first claim = %+v, %v", first, ok)
+	}
+	keyed, ok := claim(time.Minute)
+	if !ok || keyed.Kind != "keyed" {
+		t.Fatalf("second claim = %+v, %v", keyed, ok)
+	}
+	if j, ok := claim(time.Minute); ok {
+		t.Fatalf("claimed %+v before it was due", j)
+	}
+
+	if err := s.Retry(ctx, first.ID, 1, now.Add(-time.Second), "boom"); err != nil {
+		t.Fatal(err)
+	}
+	again, ok := claim(time.Minute)
+	if !ok || again.ID != first.ID || again.Attempts != 2 || again.LastError != "boom" {
+		t.Fatalf("claim after retry = %+v, %v", again, ok)
+	}
+	// Outcomes for an attempt that was superseded are ignored.
+	if err := s.Complete(ctx, first.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Complete(ctx, first.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeadLetter(ctx, keyed.ID, 1, "bad"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A lease that has run out makes the job due again.
+	enqueue("abandoned", now.Add(-time.Minute), "")
+	if j, ok := claim(-time.Second); !ok || j.Kind != "abandoned" {
+		t.Fatalf("claim = %+v, %v", j, ok)
+	}
+	if j, ok := claim(time.Minute); !ok || j.Kind != "abandoned" || j.Attempts != 2 {
+		t.Fatalf("claim after the lease expired = %+v, %v", j, ok)
+	}
+
+	counts, err := s.Counts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[Pending] != 1 || counts[Running] != 1 || counts[Done] != 1 || counts[Dead] != 1 {
+		t.Errorf("counts = %v", counts)
+	}
+	dead, err := s.List(ctx, Dead, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].ID != keyed.ID || dead[0].LastError != "bad" {
+		t.Errorf("dead jobs = %+v", dead)
+	}
+	all, err := s.List(ctx, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Kind != "abandoned" || all[1].ID != keyed.ID {
+		t.Errorf("newest jobs = %+v", all)
+	}
+
+	if err := s.Prune(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	counts, err = s.Counts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[Done] != 0 || counts[Dead] != 0 || counts[Pending] != 1 || counts[Running] != 1 {
+		t.Errorf("counts after pruning = %v", counts)
+	}
+	// Pruning frees the key.
+	if !enqueue("keyed", now, "k") {
+		t.Error("key still taken after its job was pruned")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+// TestPostgres runs against CHIRPY_TEST_POSTGRES_URL when it is set.
+func TestPostgres(t *testing.T) {
+	dbURL := os.Getenv("CHIRPY_TEST_POSTGRES_URL")
+	if dbURL == "" {
+		t.Skip("CHIRPY_TEST_POSTGRES_URL not set")
+	}
+	conn, err := store.Open(dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.DB.Close()
+	m, err := migrate.New(conn.DB, conn.Backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.DB.Exec("DELETE FROM jobs"); err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, NewPostgres(conn.DB))
+}
+
+func TestQueue(t *testing.T) {
+	mem := NewMemory()
+	q, err := New(mem, Options{
+		Concurrency:  2,
+		PollInterval: 10 * time.Millisecond,
+		MaxAttempts:  3,
+		Timeout:      time.Second,
+		Retention:    time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.backoff = func(int) time.Duration { return 0 }
+
+	var flaky atomic.Int32
+	q.Register("flaky", func(ctx context.Context, payload json.RawMessage) error {
+		if flaky.Add(1) < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	q.Register("broken", func(ctx context.Context, payload json.RawMessage) error {
+		return errors.New("always fails")
+	})
+	q.Register("panics", func(ctx context.Context, payload json.RawMessage) error {
+		panic("oops")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- q.Run(ctx) }()
+	for _, kind := range []string{"flaky", "broken", "panics", "mystery"} {
+		if err := q.Enqueue(ctx, kind, map[string]string{"kind": kind}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		counts, _ := mem.Counts(ctx)
+		if counts[Done] == 1 && counts[Dead] == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("counts = %v, want 1 done and 3 dead", counts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, _ := mem.List(context.Background(), "", 10)
+	want := map[string]struct {
+		state    string
+		attempts int
+		err      string
+	}{
+		"flaky":   {Done, 3, ""},
+		"broken":  {Dead, 3, "always fails"},
+		"panics":  {Dead, 3, "panic: oops"},
+		"mystery": {Dead, 1, "no handler"},
+	}
+	for _, j := range jobs {
+		w := want[j.Kind]
+		if j.State != w.state || j.Attempts != w.attempts || !strings.Contains(j.LastError, w.err) || (w.err == "" && j.LastError != "") {
+			t.Errorf("%s job = %+v, want %s after %d attempts with error %q", j.Kind, j, w.state, w.attempts, w.err)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	mem := NewMemory()
+	q, err := New(mem, Options{Concurrency: 1, PollInterval: time.Second, MaxAttempts: 1, Timeout: time.Second, Retention: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Schedule("bad", "every tuesday", "tick", nil); err == nil {
+		t.Error("accepted a bad cron spec")
+	}
+	if err := q.Schedule("tick", "*/5 * * * *", "tick", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each replica enqueues the occurrence when it comes round; only the
+	// first gets a job in.
+	s := q.schedules[0]
+	next := s.spec.Next(time.Date(2025, 1, 1, 12, 1, 0, 0, time.UTC))
+	if !next.Equal(time.Date(2025, 1, 1, 12, 5, 0, 0, time.UTC)) {
+		t.Errorf("next occurrence = %v", next)
+	}
+	for range 2 {
+		if err := q.enqueueOccurrence(context.Background(), s, next); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counts, _ := mem.Counts(context.Background()); counts[Pending] != 1 {
+		t.Errorf("counts = %v, want one pending job", counts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	prev := time.Duration(0)
+	for attempt := 1; attempt <= 10; attempt++ {
+		d := backoff(attempt)
+		if d < prev*3/4 || d > maxBackoff*5/4 {
+			t.Errorf("backoff(%d) = %v after %v", attempt, d, prev)
+		}
+		prev = d
+	}
+	if d := backoff(1); d < 30*time.Second || d > 38*time.Second {
+		t.Errorf("backoff(1) = %v, want about 30s", d)
+	}
+}
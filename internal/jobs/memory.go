@@ -0,0 +1,150 @@
+package jobs
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Memory keeps jobs in process memory. They are lost on restart and each
+// instance has its own, so it suits a single instance and tests.
+type Memory struct {
+	mu     sync.Mutex
+	jobs   map[int64]*memJob
+	keys   map[string]int64
+	nextID int64
+	now    func() time.Time
+}
+
+type memJob struct {
+	Job
+	key         string
+	lockedUntil time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{jobs: map[int64]*memJob{}, keys: map[string]int64{}, now: time.Now}
+}
+
+func (m *Memory) Enqueue(ctx context.Context, j NewJob) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, taken := m.keys[j.Key]; taken && j.Key != "" {
+		return false, nil
+	}
+	m.nextID++
+	now := m.now()
+	m.jobs[m.nextID] = &memJob{
+		Job: Job{
+			ID:          m.nextID,
+			Kind:        j.Kind,
+			Payload:     j.Payload,
+			State:       Pending,
+			MaxAttempts: j.MaxAttempts,
+			RunAt:       j.RunAt,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		},
+		key: j.Key,
+	}
+	if j.Key != "" {
+		m.keys[j.Key] = m.nextID
+	}
+	return true, nil
+}
+
+func (m *Memory) Claim(ctx context.Context, lease time.Duration) (Job, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	var next *memJob
+	for _, j := range m.jobs {
+		due := (j.State == Pending && !j.RunAt.After(now)) || (j.State == Running && j.lockedUntil.Before(now))
+		if due && (next == nil || cmp.Or(j.RunAt.Compare(next.RunAt), cmp.Compare(j.ID, next.ID)) < 0) {
+			next = j
+		}
+	}
+	if next == nil {
+		return Job{}, false, nil
+	}
+	next.State = Running
+	next.Attempts++
+	next.lockedUntil = now.Add(lease)
+	next.UpdatedAt = now
+	return next.Job, true, nil
+}
+
+// finish moves the job claimed for attempt into state. m.mu must be held.
+func (m *Memory) finish(id int64, attempt int, state, reason string) *memJob {
+	j, ok := m.jobs[id]
+	if !ok || j.State != Running || j.Attempts != attempt {
+		return nil
+	}
+	j.State = state
+	j.LastError = reason
+	j.lockedUntil = time.Time{}
+	j.UpdatedAt = m.now()
+	return j
+}
+
+func (m *Memory) Complete(ctx context.Context, id int64, attempt int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.finish(id, attempt, Done, "")
+	return nil
+}
+
+func (m *Memory) Retry(ctx context.Context, id int64, attempt int, runAt time.Time, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j := m.finish(id, attempt, Pending, reason); j != nil {
+		j.RunAt = runAt
+	}
+	return nil
+}
+
+func (m *Memory) DeadLetter(ctx context.Context, id int64, attempt int, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.finish(id, attempt, Dead, reason)
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, state string, limit int) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Job
+	for _, j := range m.jobs {
+		if state == "" || j.State == state {
+			out = append(out, j.Job)
+		}
+	}
+	slices.SortFunc(out, func(a, b Job) int { return cmp.Compare(b.ID, a.ID) })
+	return out[:min(len(out), limit)], nil
+}
+
+func (m *Memory) Counts(ctx context.Context) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := map[string]int{}
+	for _, j := range m.jobs {
+		counts[j.State]++
+	}
+	return counts, nil
+}
+
+func (m *Memory) Prune(ctx context.Context, cutoff time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, j := range m.jobs {
+		if (j.State == Done || j.State == Dead) && j.UpdatedAt.Before(cutoff) {
+			delete(m.jobs, id)
+			if j.key != "" {
+				delete(m.keys, j.key)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Postgres keeps jobs in the jobs table, so they survive restarts and any
+// replica's workers can run them. Workers claim jobs with SKIP LOCKED, so
+// they never wait on each other's rows.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+const jobColumns = `id, kind, payload, state, attempts, max_attempts, run_at, COALESCE(last_error, ''), created_at, updated_at`
+
+const enqueueJob = `
+INSERT INTO jobs (kind, payload, max_attempts, run_at, unique_key)
+VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+ON CONFLICT (unique_key) DO NOTHING
+`
+
+// claimJob locks the next due job, skipping those other workers hold, and
+// leases it in the same statement.
+const claimJob = `
+UPDATE jobs
+SET state = 'running', attempts = attempts + 1,
+    locked_until = now() + make_interval(secs => $1), updated_at = now()
+WHERE id = (
+    SELECT id FROM jobs
+    WHERE (state = 'pending' AND run_at <= now())
+       OR (state = 'running' AND locked_until < now())
+    ORDER BY run_at, id
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING ` + jobColumns
+
+const finishJob = `
+UPDATE jobs
+SET state = $3, run_at = COALESCE($4, run_at), last_error = NULLIF($5, ''),
+    locked_until = NULL, updated_at = now()
+WHERE id = $1 AND attempts = $2 AND state = 'running'
+`
+
+const listJobs = `
+SELECT ` + jobColumns + `
+FROM jobs
+WHERE $1 = '' OR state = $1
+ORDER BY id DESC
+LIMIT $2
+`
+
+const countJobs = `SELECT state, count(*) FROM jobs GROUP BY state`
+
+const pruneJobs = `DELETE FROM jobs WHERE state IN ('done', 'dead') AND updated_at < $1`
+
+func scanJob(row interface{ Scan(...any) error }) (Job, error) {
+	var j Job
+	var payload []byte
+	err := row.Scan(&j.ID, &j.Kind, &payload, &j.State, &j.Attempts, &j.MaxAttempts,
+		&j.RunAt, &j.LastError, &j.CreatedAt, &j.UpdatedAt)
+	j.Payload = payload
+	return j, err
+}
+
+func (s *Postgres) Enqueue(ctx context.Context, j NewJob) (bool, error) {
+	payload := j.Payload
+	if payload == nil {
+		payload = []byte("null")
+	}
+	res, err := s.db.ExecContext(ctx, enqueueJob, j.Kind, []byte(payload), j.MaxAttempts, j.RunAt, j.Key)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *Postgres) Claim(ctx context.Context, lease time.Duration) (Job, bool, error) {
+	j, err := scanJob(s.db.QueryRowContext(ctx, claimJob, lease.Seconds()))
+	if err == sql.ErrNoRows {
+		return Job{}, false, nil
+	}
+	return j, err == nil, err
+}
+
+func (s *Postgres) finish(ctx context.Context, id int64, attempt int, state string, runAt *time.Time, reason string) error {
+	_, err := s.db.ExecContext(ctx, finishJob, id, attempt, state, runAt, reason)
+	return err
+}
+
+func (s *Postgres) Complete(ctx context.Context, id int64, attempt int) error {
+	return s.finish(ctx, id, attempt, Done, nil, "")
+}
+
+func (s *Postgres) Retry(ctx context.Context, id int64, attempt int, runAt time.Time, reason string) error {
+	return s.finish(ctx, id, attempt, Pending, &runAt, reason)
+}
+
+func (s *Postgres) DeadLetter(ctx context.Context, id int64, attempt int, reason string) error {
+	return s.finish(ctx, id, attempt, Dead, nil, reason)
+}
+
+func (s *Postgres) List(ctx context.Context, state string, limit int) ([]Job, error) {
+	rows, err := s.db.QueryContext(ctx, listJobs, state, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	return out, rows.Err()
+}
+
+func (s *Postgres) Counts(ctx context.Context) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, countJobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var state string
+		var n int
+		if err := rows.Scan(&state, &n); err != nil {
+			return nil, err
+		}
+		counts[state] = n
+	}
+	return counts, rows.Err()
+}
+
+func (s *Postgres) Prune(ctx context.Context, cutoff time.Time) error {
+	_, err := s.db.ExecContext(ctx, pruneJobs, cutoff)
+	return err
+}
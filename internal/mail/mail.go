@@ -0,0 +1,122 @@
+// Package mail sends the email the server writes, such as address
+// verification links.
+//
+// A Mailer delivers plain text Messages from a fixed sender. SMTP hands them
+// to a mail server, Writer writes them out for development, and Memory keeps
+// them for tests.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	netmail "net/mail"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email. The Mailer supplies the sender.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// format renders msg as an RFC 5322 message from the given sender, with
+// CRLF line endings throughout.
+func format(from string, msg Message, date time.Time) ([]byte, error) {
+	sender, err := netmail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("mail: sender %q: %w", from, err)
+	}
+	to, err := netmail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("mail: recipient %q: %w", msg.To, err)
+	}
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, errors.New("mail: subject contains a line break")
+	}
+
+	var b bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	header("From", sender.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	b.WriteString("\r\n")
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if !strings.HasSuffix(body, "\n") {
+		b.WriteString("\r\n")
+	}
+	return b.Bytes(), nil
+}
+
+// Writer writes every message, headers and all, to an io.Writer such as
+// stderr or a log file. It is meant for development, where links can be
+// copied out of its output.
+type Writer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+func NewWriter(w io.Writer, from string) *Writer {
+	return &Writer{w: w, from: from}
+}
+
+func (m *Writer) Send(ctx context.Context, msg Message) error {
+	dat, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// A blank line and a dashed rule keep consecutive messages apart.
+	_, err = fmt.Fprintf(m.w, "%s\r\n%s\r\n", dat, strings.Repeat("-", 72))
+	return err
+}
+
+// Memory keeps the messages sent through it so tests can read them.
+type Memory struct {
+	mu   sync.Mutex
+	msgs []Message
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Send records msg. It rejects the messages the other mailers would, so
+// tests catch them too.
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	if _, err := format("chirpy@localhost", msg, time.Now()); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.msgs = append(m.msgs, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.msgs)
+}
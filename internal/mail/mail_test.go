@@ -0,0 +1,174 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	date := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	dat, err := format("Chirpy <noreply@chirpy.example>", Message{
+		To:      "a@example.com",
+		Subject: "Vérifiez",
+		Body:    "line one\nline two",
+	}, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(dat)
+	for _, want := range []string{
+		"From: \"Chirpy\" <noreply@chirpy.example>\r\n",
+		"To: <a@example.com>\r\n",
+		"Subject: =?utf-8?q?V=C3=A9rifiez?=\r\n",
+		"Date: Thu, 02 Jan 2025 03:04:05 +0000\r\n",
+		"\r\n\r\nline one\r\nline two\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("message does not contain %q:\n%s", want, got)
+		}
+	}
+
+	for _, msg := range []Message{
+		{To: "not an address", Subject: "hi"},
+		{To: "a@example.com\r\nBcc: b@example.com", Subject: "hi"},
+		{To: "a@example.com", Subject: "hi\r\nBcc: b@example.com"},
+	} {
+		if _, err := format("noreply@chirpy.example", msg, date); err == nil {
+			t.Errorf("format(%q) accepted a bad header", msg)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b, "noreply@chirpy.example")
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := w.Send(context.Background(), Message{To: to, Subject: "hi", Body: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := strings.Count(b.String(), "Subject: hi\r\n"); n != 2 {
+		t.Errorf("wrote %d messages, want 2:\n%s", n, b.String())
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	msg := Message{To: "a@example.com", Subject: "hi", Body: "hello"}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(context.Background(), Message{To: "nobody"}); err == nil {
+		t.Error("accepted a bad recipient")
+	}
+	if got := m.Messages(); len(got) != 1 || got[0] != msg {
+		t.Errorf("Messages() = %+v", got)
+	}
+}
+
+// fakeSMTP accepts one message on a local port and sends the commands and
+// data it received on the returned channel.
+func fakeSMTP(t *testing.T) (*net.TCPAddr, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	got := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var log strings.Builder
+		defer func() { got <- log.String() }()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 fake ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			log.WriteString(line)
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO":
+				reply("250 fake")
+			case "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					log.WriteString(line)
+					if line == ".\r\n" {
+						break
+					}
+				}
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return l.Addr().(*net.TCPAddr), got
+}
+
+func TestSMTP(t *testing.T) {
+	addr, got := fakeSMTP(t)
+	s := NewSMTP(addr.IP.String(), addr.Port, "", "", "Chirpy <noreply@chirpy.example>")
+	err := s.Send(context.Background(), Message{To: "a@example.com", Subject: "hi", Body: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := <-got
+	for _, want := range []string{
+		"MAIL FROM:<noreply@chirpy.example>",
+		"RCPT TO:<a@example.com>",
+		"Subject: hi\r\n",
+		"\r\nhello\r\n.\r\n",
+		"QUIT",
+	} {
+		if !strings.Contains(session, want) {
+			t.Errorf("session does not contain %q:\n%s", want, session)
+		}
+	}
+}
+
+func TestSMTPCanceled(t *testing.T) {
+	// A server that never greets must not hold Send past its context.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+	tcp := l.Addr().(*net.TCPAddr)
+	s := NewSMTP(tcp.IP.String(), tcp.Port, "", "", "noreply@chirpy.example")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Send(ctx, Message{To: "a@example.com", Subject: "hi"}); err == nil {
+		t.Fatal("Send succeeded without a server")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Send took %v after its context ended", d)
+	}
+}
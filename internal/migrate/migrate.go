@@ -0,0 +1,175 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"text/tabwriter"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/store"
+	"github.com/05blue04/chirpy/sql/schema"
+	sqliteschema "github.com/05blue04/chirpy/sql/sqlite/schema"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// lockID is the Postgres advisory lock key held while migrating, so that
+// replicas starting at the same time apply migrations one at a time. It
+// spells "chirpy" in ASCII. SQLite is single-node and relies on its own file
+// locking.
+const lockID = 0x636869727079
+
+// Migrator applies the migrations embedded from sql/schema, or from
+// sql/sqlite/schema for the SQLite backend.
+type Migrator struct {
+	provider *goose.Provider
+}
+
+func New(db *sql.DB, backend store.Backend) (*Migrator, error) {
+	opts := []goose.ProviderOption{goose.WithSlog(slog.Default())}
+	dialect := goose.DialectSQLite3
+	if backend == store.Postgres {
+		dialect = goose.DialectPostgres
+		locker, err := lock.NewPostgresSessionLocker(lock.WithLockID(lockID))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, goose.WithSessionLocker(locker))
+	}
+
+	provider, err := goose.NewProvider(dialect, db, migrations(backend), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{provider: provider}, nil
+}
+
+func migrations(backend store.Backend) fs.FS {
+	if backend == store.SQLite {
+		return sqliteschema.FS
+	}
+	return schema.FS
+}
+
+// Latest returns the version of the newest embedded migration for backend,
+// which is the schema version this build expects.
+func Latest(backend store.Backend) (int64, error) {
+	matches, err := fs.Glob(migrations(backend), "*.sql")
+	if err != nil {
+		return 0, err
+	}
+	var latest int64
+	for _, name := range matches {
+		version, err := goose.NumericComponent(name)
+		if err != nil {
+			return 0, err
+		}
+		latest = max(latest, version)
+	}
+	return latest, nil
+}
+
+func (m *Migrator) Up(ctx context.Context) ([]*goose.MigrationResult, error) {
+	return m.provider.Up(ctx)
+}
+
+func (m *Migrator) Down(ctx context.Context) (*goose.MigrationResult, error) {
+	return m.provider.Down(ctx)
+}
+
+// Redo rolls back the most recent migration and applies it again.
+func (m *Migrator) Redo(ctx context.Context) ([]*goose.MigrationResult, error) {
+	down, err := m.provider.Down(ctx)
+	if err != nil {
+		return nil, err
+	}
+	up, err := m.provider.UpByOne(ctx)
+	if err != nil {
+		return []*goose.MigrationResult{down}, err
+	}
+	return []*goose.MigrationResult{down, up}, nil
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]*goose.MigrationStatus, error) {
+	return m.provider.Status(ctx)
+}
+
+// Run executes a migrate subcommand (up, down, status or redo) and writes a
+// human-readable report to out.
+func (m *Migrator) Run(ctx context.Context, command string, out io.Writer) error {
+	switch command {
+	case "up":
+		results, err := m.Up(ctx)
+		printResults(out, results)
+		if err == nil && len(results) == 0 {
+			fmt.Fprintln(out, "no migrations to apply")
+		}
+		return err
+	case "down":
+		result, err := m.Down(ctx)
+		if result != nil {
+			printResults(out, []*goose.MigrationResult{result})
+		}
+		return err
+	case "redo":
+		results, err := m.Redo(ctx)
+		printResults(out, results)
+		return err
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tSTATE\tAPPLIED AT\tSOURCE")
+		for _, s := range statuses {
+			applied := "-"
+			if !s.AppliedAt.IsZero() {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.Source.Version, s.State, applied, s.Source.Path)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down, status or redo)", command)
+	}
+}
+
+func printResults(out io.Writer, results []*goose.MigrationResult) {
+	for _, r := range results {
+		fmt.Fprintf(out, "%-4s %s (%s)\n", r.Direction, r.Source.Path, r.Duration.Round(time.Millisecond))
+	}
+}
+
+// CurrentVersion reads the applied version from goose's bookkeeping table
+// without taking the migration lock, so it is cheap enough for health
+// checks. A version that was migrated down has a later is_applied=false row
+// that cancels the earlier one, so the history is walked newest first.
+func CurrentVersion(ctx context.Context, db *sql.DB) (int64, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version_id, is_applied FROM goose_db_version ORDER BY id DESC`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	skip := map[int64]bool{}
+	for rows.Next() {
+		var version int64
+		var applied bool
+		if err := rows.Scan(&version, &applied); err != nil {
+			return 0, err
+		}
+		if skip[version] {
+			continue
+		}
+		if applied {
+			return version, nil
+		}
+		skip[version] = true
+	}
+	return 0, rows.Err()
+}
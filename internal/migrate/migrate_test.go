@@ -0,0 +1,44 @@
+package migrate
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/05blue04/chirpy/internal/store"
+)
+
+func TestLatest(t *testing.T) {
+	for _, backend := range []store.Backend{store.Postgres, store.SQLite} {
+		files, err := fs.Glob(migrations(backend), "*.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 {
+			t.Fatalf("no %s migrations embedded", backend)
+		}
+
+		latest, err := Latest(backend)
+		if err != nil {
+			t.Fatalf("Latest(%s) error = %v", backend, err)
+		}
+		if latest != int64(len(files)) {
+			t.Errorf("Latest(%s) = %d, want %d (one per embedded file)", backend, latest, len(files))
+		}
+	}
+}
+
+// The backends are kept at the same version so readiness and operators see
+// one schema history.
+func TestBackendsInStep(t *testing.T) {
+	pg, err := Latest(store.Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lite, err := Latest(store.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pg != lite {
+		t.Errorf("postgres schema is at %d but sqlite is at %d", pg, lite)
+	}
+}
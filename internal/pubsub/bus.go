@@ -0,0 +1,26 @@
+package pubsub
+
+import "context"
+
+// Bus carries published events to the hub of every replica, including the
+// publisher's own. Memory is enough for a single instance; Postgres fans
+// events out between replicas.
+type Bus interface {
+	// Publish sends e to every hub listening on the bus. The hubs assign
+	// its ID, so e.ID is ignored.
+	Publish(ctx context.Context, e Event) error
+}
+
+// Memory delivers events straight to a single hub.
+type Memory struct {
+	hub *Hub
+}
+
+func NewMemory(hub *Hub) *Memory {
+	return &Memory{hub: hub}
+}
+
+func (b *Memory) Publish(ctx context.Context, e Event) error {
+	b.hub.Publish(e)
+	return nil
+}
@@ -0,0 +1,106 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+)
+
+func TestMemory(t *testing.T) {
+	h := NewHub(1)
+	sub, _ := h.Subscribe("", nil)
+	defer sub.Close()
+
+	author := uuid.New()
+	if err := NewMemory(h).Publish(context.Background(), Event{Type: "chirp.created", Author: author}); err != nil {
+		t.Fatal(err)
+	}
+	if e := receive(t, sub); e.Author != author || e.ID == "" {
+		t.Errorf("got %+v", e)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	in := Event{
+		ID:     "ignored-1",
+		Type:   "chirp.created",
+		Author: uuid.New(),
+		Tags:   []string{"go"},
+		Data:   json.RawMessage(`{"body":"#go"}`),
+	}
+	payload, err := encode(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := decode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != "" || out.Type != in.Type || out.Author != in.Author ||
+		strings.Join(out.Tags, ",") != "go" || string(out.Data) != string(in.Data) {
+		t.Errorf("decode(encode(%+v)) = %+v", in, out)
+	}
+
+	big := Event{Type: "chirp.created", Data: json.RawMessage(`"` + strings.Repeat("x", maxPayload) + `"`)}
+	if _, err := encode(big); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("encoding a %d byte event: err = %v, want ErrTooLarge", len(big.Data), err)
+	}
+}
+
+// TestPostgres runs against CHIRPY_TEST_POSTGRES_URL when it is set. Two
+// buses on one database stand in for two replicas.
+func TestPostgres(t *testing.T) {
+	dbURL := os.Getenv("CHIRPY_TEST_POSTGRES_URL")
+	if dbURL == "" {
+		t.Skip("CHIRPY_TEST_POSTGRES_URL not set")
+	}
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var subs []*Subscription
+	var buses []*Postgres
+	for range 2 {
+		h := NewHub(1)
+		sub, _ := h.Subscribe("", nil)
+		defer sub.Close()
+		bus := NewPostgres(db, dbURL, h)
+		go bus.Run(ctx)
+		subs = append(subs, sub)
+		buses = append(buses, bus)
+	}
+
+	// The listeners connect in the background, so publish until both
+	// replicas have heard from the first.
+	author := uuid.New()
+	deadline := time.After(10 * time.Second)
+	for i, sub := range subs {
+		for received := false; !received; {
+			if err := buses[0].Publish(ctx, Event{Type: "chirp.created", Author: author, Data: json.RawMessage(`{}`)}); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case e := <-sub.C:
+				if e.Author != author {
+					t.Fatalf("replica %d got %+v", i, e)
+				}
+				received = true
+			case <-time.After(100 * time.Millisecond):
+			case <-deadline:
+				t.Fatalf("replica %d received nothing", i)
+			}
+		}
+	}
+}
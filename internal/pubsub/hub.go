@@ -0,0 +1,207 @@
+// Package pubsub fans events out to in-process subscribers, such as the
+// clients of the chirp stream, and keeps the most recent ones so a
+// subscriber that reconnects can catch up on what it missed.
+//
+// Events are published through a Bus, which delivers them to the Hub of
+// every replica: Memory for a single instance, Postgres to share them
+// between replicas.
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reset is the type of the event a subscriber gets in place of events that
+// can no longer be replayed. It should reload whatever state it keeps.
+const Reset = "reset"
+
+// queueSize is how many events may wait for a subscriber. One that falls
+// further behind is dropped rather than holding up the publisher.
+const queueSize = 64
+
+// Why the hub closed a subscription.
+var (
+	ErrSlow   = errors.New("pubsub: subscriber fell behind")
+	ErrClosed = errors.New("pubsub: hub closed")
+)
+
+// Event is a message published to a hub.
+type Event struct {
+	// ID is assigned by the hub. It identifies both the hub and the
+	// event's place in its sequence, so a subscriber can resume from it.
+	ID   string
+	Type string
+	// Author is the user the event is about: a chirp's author, or the
+	// user whose account changed.
+	Author uuid.UUID
+	// Tags are the hashtags of the chirp the event is about, lowercased.
+	Tags []string
+	Data json.RawMessage
+
+	seq uint64
+}
+
+// Hub delivers published events to every subscriber whose filter accepts
+// them, and buffers the last few for replay.
+type Hub struct {
+	// epoch distinguishes this hub's event IDs from those of an earlier
+	// process or another replica, which it cannot replay.
+	epoch string
+
+	mu     sync.Mutex
+	seq    uint64
+	replay []Event
+	size   int
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewHub returns a hub that buffers the last replay events.
+func NewHub(replay int) *Hub {
+	return &Hub{
+		epoch:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		replay: make([]Event, 0, replay),
+		size:   replay,
+		subs:   map[*Subscription]struct{}{},
+	}
+}
+
+// Subscription receives events on C until it is closed, either by Close or
+// by the hub. The hub closes it when the hub shuts down or when the
+// subscriber stops keeping up.
+type Subscription struct {
+	C <-chan Event
+
+	c     chan Event
+	hub   *Hub
+	match func(Event) bool
+	err   error
+}
+
+// Publish assigns e an ID and delivers it. It never blocks.
+func (h *Hub) Publish(e Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e.seq = h.seq
+	e.ID = h.id(h.seq)
+
+	if h.size > 0 {
+		if len(h.replay) == h.size {
+			copy(h.replay, h.replay[1:])
+			h.replay = h.replay[:h.size-1]
+		}
+		h.replay = append(h.replay, e)
+	}
+
+	for sub := range h.subs {
+		if sub.match != nil && !sub.match(e) {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+			// A stalled subscriber would otherwise hold up every
+			// publisher. Closing it tells the client to reconnect and
+			// resume from its last event.
+			h.remove(sub, ErrSlow)
+		}
+	}
+	return e
+}
+
+// Subscribe registers a subscriber for the events match accepts, or all of
+// them if match is nil. If lastID is not empty, the buffered events after it
+// are returned to be delivered before any on the subscription. If some of
+// them have already left the buffer, or lastID is not one of this hub's,
+// missed is a single Reset event instead, carrying the latest ID.
+func (h *Hub) Subscribe(lastID string, match func(Event) bool) (sub *Subscription, missed []Event) {
+	c := make(chan Event, queueSize)
+	sub = &Subscription{C: c, c: c, hub: h, match: match}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if lastID != "" {
+		missed = h.since(lastID, match)
+	}
+	if h.closed {
+		sub.err = ErrClosed
+		close(c)
+		return sub, missed
+	}
+	h.subs[sub] = struct{}{}
+	return sub, missed
+}
+
+func (h *Hub) since(lastID string, match func(Event) bool) []Event {
+	epoch, seqStr, _ := strings.Cut(lastID, "-")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	oldest := h.seq + 1 - uint64(len(h.replay))
+	if epoch != h.epoch || err != nil || seq > h.seq || seq+1 < oldest {
+		return []Event{{ID: h.id(h.seq), Type: Reset, Data: json.RawMessage("{}"), seq: h.seq}}
+	}
+
+	var missed []Event
+	for _, e := range h.replay {
+		if e.seq > seq && (match == nil || match(e)) {
+			missed = append(missed, e)
+		}
+	}
+	return missed
+}
+
+func (h *Hub) id(seq uint64) string {
+	return h.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// remove unregisters sub and closes its channel, recording why. h.mu must
+// be held.
+func (h *Hub) remove(sub *Subscription, err error) {
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		sub.err = err
+		close(sub.c)
+	}
+}
+
+// Close ends every subscription, so that long-lived handlers return and the
+// server can shut down. Later subscriptions are closed at once.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		h.remove(sub, ErrClosed)
+	}
+}
+
+// Subscribers reports how many subscriptions are open.
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Close unregisters the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s, nil)
+}
+
+// Err reports why the hub closed the subscription: ErrSlow or ErrClosed. It
+// is nil while the subscription is open or if it was closed by Close.
+func (s *Subscription) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.err
+}
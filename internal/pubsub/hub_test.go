@@ -0,0 +1,154 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func publish(h *Hub, author uuid.UUID, n int) []Event {
+	var out []Event
+	for range n {
+		out = append(out, h.Publish(Event{Type: "chirp.created", Author: author, Data: json.RawMessage(`{}`)}))
+	}
+	return out
+}
+
+func receive(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case e, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return e
+	default:
+		t.Fatal("no event delivered")
+	}
+	return Event{}
+}
+
+func TestPublishFilters(t *testing.T) {
+	h := NewHub(8)
+	walt, jesse := uuid.New(), uuid.New()
+
+	all, _ := h.Subscribe("", nil)
+	defer all.Close()
+	onlyWalt, _ := h.Subscribe("", func(e Event) bool { return e.Author == walt })
+	defer onlyWalt.Close()
+
+	first := publish(h, jesse, 1)[0]
+	second := publish(h, walt, 1)[0]
+	if first.ID == "" || first.ID == second.ID {
+		t.Fatalf("IDs %q and %q", first.ID, second.ID)
+	}
+
+	if e := receive(t, all); e.ID != first.ID {
+		t.Errorf("first event = %q, want %q", e.ID, first.ID)
+	}
+	if e := receive(t, all); e.ID != second.ID {
+		t.Errorf("second event = %q, want %q", e.ID, second.ID)
+	}
+	if e := receive(t, onlyWalt); e.Author != walt {
+		t.Errorf("filtered subscription got an event by %v", e.Author)
+	}
+	if len(onlyWalt.C) != 0 {
+		t.Error("filtered subscription got extra events")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	h := NewHub(3)
+	author := uuid.New()
+	events := publish(h, author, 5)
+
+	tests := []struct {
+		name    string
+		lastID  string
+		wantIDs []string
+		reset   bool
+	}{
+		{name: "fresh", lastID: ""},
+		{name: "up to date", lastID: events[4].ID},
+		{name: "within buffer", lastID: events[2].ID, wantIDs: []string{events[3].ID, events[4].ID}},
+		{name: "oldest buffered", lastID: events[1].ID, wantIDs: []string{events[2].ID, events[3].ID, events[4].ID}},
+		{name: "evicted", lastID: events[0].ID, reset: true},
+		{name: "another hub", lastID: "elsewhere-4", reset: true},
+		{name: "future", lastID: h.id(9), reset: true},
+		{name: "garbage", lastID: "garbage", reset: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, missed := h.Subscribe(tt.lastID, nil)
+			defer sub.Close()
+
+			if tt.reset {
+				if len(missed) != 1 || missed[0].Type != Reset || missed[0].ID != events[4].ID {
+					t.Fatalf("missed = %+v, want a reset at %q", missed, events[4].ID)
+				}
+				return
+			}
+			var got []string
+			for _, e := range missed {
+				got = append(got, e.ID)
+			}
+			if len(got) != len(tt.wantIDs) {
+				t.Fatalf("missed = %q, want %q", got, tt.wantIDs)
+			}
+			for i := range got {
+				if got[i] != tt.wantIDs[i] {
+					t.Errorf("missed = %q, want %q", got, tt.wantIDs)
+				}
+			}
+		})
+	}
+
+	// A reset carries the latest ID, so resuming from it needs no second
+	// reset.
+	_, missed := h.Subscribe(events[0].ID, nil)
+	if _, again := h.Subscribe(missed[0].ID, nil); len(again) != 0 {
+		t.Errorf("resuming from a reset replayed %+v", again)
+	}
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	h := NewHub(0)
+	slow, _ := h.Subscribe("", nil)
+	fast, _ := h.Subscribe("", nil)
+
+	for range queueSize {
+		publish(h, uuid.New(), 1)
+		<-fast.C
+	}
+	publish(h, uuid.New(), 1)
+
+	for range queueSize {
+		<-slow.C
+	}
+	if _, ok := <-slow.C; ok || slow.Err() != ErrSlow {
+		t.Errorf("slow subscriber open = %v, Err() = %v, want closed with ErrSlow", ok, slow.Err())
+	}
+	if _, ok := <-fast.C; !ok || fast.Err() != nil {
+		t.Error("fast subscriber was dropped")
+	}
+	if n := h.Subscribers(); n != 1 {
+		t.Errorf("Subscribers() = %d, want 1", n)
+	}
+	slow.Close() // already closed by the hub; must not panic
+}
+
+func TestClose(t *testing.T) {
+	h := NewHub(1)
+	sub, _ := h.Subscribe("", nil)
+	h.Close()
+	if _, ok := <-sub.C; ok || sub.Err() != ErrClosed {
+		t.Errorf("subscription open = %v, Err() = %v after the hub closed", ok, sub.Err())
+	}
+	late, _ := h.Subscribe("", nil)
+	if _, ok := <-late.C; ok {
+		t.Error("subscription made after Close is open")
+	}
+	sub.Close()
+	late.Close()
+}
@@ -0,0 +1,124 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Channel is the Postgres notification channel events are sent on.
+const Channel = "chirpy_events"
+
+// maxPayload is the largest notification payload Postgres accepts in its
+// default configuration.
+const maxPayload = 7999
+
+// pingInterval is how often an idle listener checks its connection, which
+// can otherwise die without the listener noticing.
+const pingInterval = 90 * time.Second
+
+// ErrTooLarge is returned by Postgres.Publish for events whose payload
+// would not fit in a notification.
+var ErrTooLarge = errors.New("pubsub: event too large for a notification")
+
+// Postgres sends events with NOTIFY and receives them with LISTEN, so an
+// event published on one replica reaches the hubs of all of them. Each hub
+// numbers the events itself, so a client that resumes on another replica
+// gets a Reset.
+type Postgres struct {
+	db  *sql.DB
+	dsn string
+	hub *Hub
+}
+
+// NewPostgres returns a bus that publishes through db and, once Run is
+// called, delivers to hub. dsn is the database's connection string; the
+// listener keeps a connection of its own outside db's pool.
+func NewPostgres(db *sql.DB, dsn string, hub *Hub) *Postgres {
+	return &Postgres{db: db, dsn: dsn, hub: hub}
+}
+
+// wireEvent is an event as sent in a notification.
+type wireEvent struct {
+	Type   string          `json:"type"`
+	Author uuid.UUID       `json:"author"`
+	Tags   []string        `json:"tags,omitempty"`
+	Data   json.RawMessage `json:"data"`
+}
+
+func encode(e Event) (string, error) {
+	dat, err := json.Marshal(wireEvent{Type: e.Type, Author: e.Author, Tags: e.Tags, Data: e.Data})
+	if err != nil {
+		return "", err
+	}
+	if len(dat) > maxPayload {
+		return "", fmt.Errorf("%w: %d bytes", ErrTooLarge, len(dat))
+	}
+	return string(dat), nil
+}
+
+func decode(payload string) (Event, error) {
+	var w wireEvent
+	if err := json.Unmarshal([]byte(payload), &w); err != nil {
+		return Event{}, err
+	}
+	return Event{Type: w.Type, Author: w.Author, Tags: w.Tags, Data: w.Data}, nil
+}
+
+func (b *Postgres) Publish(ctx context.Context, e Event) error {
+	payload, err := encode(e)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", Channel, payload)
+	return err
+}
+
+// Run delivers the events published by every replica to the hub until ctx
+// is cancelled. The listener reconnects by itself if its connection drops;
+// events sent while it was down are lost.
+func (b *Postgres) Run(ctx context.Context) error {
+	l := pq.NewListener(b.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			slog.Warn("event bus disconnected", "error", err)
+		case pq.ListenerEventConnectionAttemptFailed:
+			slog.Warn("event bus could not reconnect", "error", err)
+		case pq.ListenerEventReconnected:
+			slog.Warn("event bus reconnected; events published while it was down were missed")
+		}
+	})
+	defer l.Close()
+	if err := l.Listen(Channel); err != nil {
+		return fmt.Errorf("pubsub: listen on %s: %w", Channel, err)
+	}
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-l.Notify:
+			// nil follows a reconnection, which the callback has logged.
+			if n == nil {
+				continue
+			}
+			e, err := decode(n.Extra)
+			if err != nil {
+				slog.Error("Error decoding event from the bus", "error", err)
+				continue
+			}
+			b.hub.Publish(e)
+		case <-ping.C:
+			go l.Ping()
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory keeps buckets in process memory. Each instance counts separately,
+// so it suits a single instance.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+func (m *Memory) Take(ctx context.Context, key string, p Policy) (float64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(p.Burst), updated: now}
+		m.buckets[key] = b
+	}
+	elapsed := max(now.Sub(b.updated).Seconds(), 0)
+	b.tokens = min(float64(p.Burst), b.tokens+elapsed*p.rate())
+	b.updated = now
+
+	if b.tokens < 1 {
+		return b.tokens, false, nil
+	}
+	b.tokens--
+	return b.tokens, true, nil
+}
+
+func (m *Memory) Prune(ctx context.Context, idle time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := m.now().Add(-idle)
+	for key, b := range m.buckets {
+		if b.updated.Before(cutoff) {
+			delete(m.buckets, key)
+		}
+	}
+	return nil
+}
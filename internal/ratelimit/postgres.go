@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Postgres keeps buckets in the rate_limit_buckets table, so every replica
+// draws from the same ones. Bucket times come from the database clock,
+// which keeps replicas with skewed clocks consistent.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// takeToken refills and takes from an existing bucket in one statement. The
+// subquery locks the row and computes the refilled count, which RETURNING
+// reports alongside the stored one.
+const takeToken = `
+UPDATE rate_limit_buckets AS b
+SET tokens = CASE WHEN r.tokens >= 1 THEN r.tokens - 1 ELSE r.tokens END,
+    updated_at = now()
+FROM (
+    SELECT key, LEAST($2::float8, tokens + GREATEST(EXTRACT(EPOCH FROM now() - updated_at)::float8, 0) * $3::float8) AS tokens
+    FROM rate_limit_buckets
+    WHERE key = $1
+    FOR UPDATE
+) AS r
+WHERE b.key = r.key
+RETURNING b.tokens, r.tokens >= 1
+`
+
+const createBucket = `
+INSERT INTO rate_limit_buckets (key, tokens, updated_at)
+VALUES ($1, $2::float8 - 1, now())
+ON CONFLICT (key) DO NOTHING
+`
+
+const pruneBuckets = `
+DELETE FROM rate_limit_buckets
+WHERE updated_at < now() - make_interval(secs => $1)
+`
+
+func (s *Postgres) Take(ctx context.Context, key string, p Policy) (float64, bool, error) {
+	tokens, ok, err := s.take(ctx, key, p)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return tokens, ok, err
+	}
+
+	res, err := s.db.ExecContext(ctx, createBucket, key, p.Burst)
+	if err != nil {
+		return 0, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return float64(p.Burst - 1), true, err
+	}
+	// Another request created the bucket first; take from it instead.
+	return s.take(ctx, key, p)
+}
+
+func (s *Postgres) take(ctx context.Context, key string, p Policy) (tokens float64, ok bool, err error) {
+	err = s.db.QueryRowContext(ctx, takeToken, key, p.Burst, p.rate()).Scan(&tokens, &ok)
+	return tokens, ok, err
+}
+
+func (s *Postgres) Prune(ctx context.Context, idle time.Duration) error {
+	_, err := s.db.ExecContext(ctx, pruneBuckets, idle.Seconds())
+	return err
+}
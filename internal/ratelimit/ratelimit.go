@@ -0,0 +1,129 @@
+// Package ratelimit implements token-bucket rate limiting.
+//
+// Each key, such as a user or client address, has a bucket per policy that
+// holds up to Burst tokens and refills at Limit tokens per Period. A request
+// takes one token and is refused when none is left. Buckets live in a Store:
+// Memory for a single instance, Postgres to share them between replicas.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// Policy is a bucket's size and refill rate.
+type Policy struct {
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+// rate is the refill rate in tokens per second.
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// refillTime is how long an empty bucket takes to fill.
+func (p Policy) refillTime() time.Duration {
+	return time.Duration(float64(p.Burst) / p.rate() * float64(time.Second))
+}
+
+// Store holds buckets.
+type Store interface {
+	// Take refills key's bucket for the time since it was last used, then
+	// takes a token from it if it holds at least one. It returns the tokens
+	// left and whether one was taken. A new bucket starts full.
+	Take(ctx context.Context, key string, p Policy) (tokens float64, ok bool, err error)
+	// Prune forgets buckets unused for at least idle.
+	Prune(ctx context.Context, idle time.Duration) error
+}
+
+// Result is the outcome of Allow.
+type Result struct {
+	Allowed bool
+	// Limit is the policy's burst, the most requests allowed at once.
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a refused request would be allowed.
+	RetryAfter time.Duration
+}
+
+// Limiter applies named policies to keys.
+type Limiter struct {
+	store    Store
+	policies map[string]Policy
+}
+
+// New returns a Limiter enforcing policies with buckets held in store.
+func New(store Store, policies map[string]Policy) (*Limiter, error) {
+	for name, p := range policies {
+		if p.Limit <= 0 || p.Period <= 0 || p.Burst <= 0 {
+			return nil, fmt.Errorf("ratelimit: policy %q needs a positive limit, period and burst", name)
+		}
+	}
+	return &Limiter{store: store, policies: policies}, nil
+}
+
+// Policy returns the named policy.
+func (l *Limiter) Policy(name string) (Policy, bool) {
+	p, ok := l.policies[name]
+	return p, ok
+}
+
+// Allow takes a token from key's bucket for the named policy.
+func (l *Limiter) Allow(ctx context.Context, policy, key string) (Result, error) {
+	p, ok := l.policies[policy]
+	if !ok {
+		return Result{}, fmt.Errorf("ratelimit: unknown policy %q", policy)
+	}
+	tokens, allowed, err := l.store.Take(ctx, policy+":"+key, p)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{
+		Allowed:   allowed,
+		Limit:     p.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(p.Burst) - tokens) / p.rate()),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / p.rate())
+	}
+	return res, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(max(s, 0) * float64(time.Second))
+}
+
+// pruneInterval is how often Run prunes idle buckets.
+const pruneInterval = time.Minute
+
+// Run prunes buckets that have been idle long enough to be full again, so
+// forgetting them changes nothing, until ctx is cancelled.
+func (l *Limiter) Run(ctx context.Context) error {
+	var idle time.Duration
+	for _, p := range l.policies {
+		idle = max(idle, p.refillTime())
+	}
+
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			// A failed prune only leaves buckets around for longer.
+			if err := l.store.Prune(ctx, idle); err != nil && ctx.Err() == nil {
+				slog.Warn("pruning rate limit buckets failed", "error", err)
+			}
+		}
+	}
+}
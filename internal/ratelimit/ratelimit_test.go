@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/migrate"
+	"github.com/05blue04/chirpy/internal/store"
+	"github.com/google/uuid"
+)
+
+// slow refills too slowly to matter during a test.
+var slow = Policy{Limit: 1, Period: time.Hour, Burst: 3}
+
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	key := uuid.NewString()
+
+	for i := range slow.Burst {
+		tokens, ok, err := s.Take(ctx, key, slow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("take %d refused, want allowed", i+1)
+		}
+		if want := float64(slow.Burst - i - 1); tokens < want || tokens > want+0.01 {
+			t.Errorf("take %d left %v tokens, want %v", i+1, tokens, want)
+		}
+	}
+	if _, ok, err := s.Take(ctx, key, slow); err != nil || ok {
+		t.Fatalf("take past burst = %v, %v; want refused", ok, err)
+	}
+
+	if _, ok, err := s.Take(ctx, uuid.NewString(), slow); err != nil || !ok {
+		t.Errorf("take on another key = %v, %v; want allowed", ok, err)
+	}
+
+	if err := s.Prune(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := s.Take(ctx, key, slow); err != nil || !ok {
+		t.Errorf("take after prune = %v, %v; want a fresh bucket", ok, err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestMemoryRefill(t *testing.T) {
+	now := time.Unix(0, 0)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	p := Policy{Limit: 1, Period: time.Second, Burst: 2}
+	ctx := context.Background()
+
+	m.Take(ctx, "k", p)
+	m.Take(ctx, "k", p)
+	if _, ok, _ := m.Take(ctx, "k", p); ok {
+		t.Fatal("empty bucket allowed a take")
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	tokens, ok, _ := m.Take(ctx, "k", p)
+	if !ok || tokens != 0.5 {
+		t.Errorf("after 1.5s take = %v, %v tokens; want allowed, 0.5", ok, tokens)
+	}
+
+	now = now.Add(time.Hour)
+	if tokens, _, _ := m.Take(ctx, "k", p); tokens != 1 {
+		t.Errorf("after an hour %v tokens left, want burst 2 minus 1", tokens)
+	}
+}
+
+// TestPostgres runs against CHIRPY_TEST_POSTGRES_URL when it is set.
+func TestPostgres(t *testing.T) {
+	dbURL := os.Getenv("CHIRPY_TEST_POSTGRES_URL")
+	if dbURL == "" {
+		t.Skip("CHIRPY_TEST_POSTGRES_URL not set")
+	}
+	conn, err := store.Open(dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.DB.Close()
+	m, err := migrate.New(conn.DB, conn.Backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, NewPostgres(conn.DB))
+}
+
+func TestAllow(t *testing.T) {
+	l, err := New(NewMemory(), map[string]Policy{"write": {Limit: 6, Period: time.Minute, Burst: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	res, err := l.Allow(ctx, "write", "user:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed || res.Limit != 2 || res.Remaining != 1 {
+		t.Errorf("first Allow = %+v, want allowed with 1 of 2 remaining", res)
+	}
+	if res.Reset < 9*time.Second || res.Reset > 10*time.Second {
+		t.Errorf("Reset = %v, want about 10s to refill one token", res.Reset)
+	}
+
+	l.Allow(ctx, "write", "user:1")
+	res, _ = l.Allow(ctx, "write", "user:1")
+	if res.Allowed || res.Remaining != 0 {
+		t.Errorf("third Allow = %+v, want refused", res)
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > 10*time.Second {
+		t.Errorf("RetryAfter = %v, want at most 10s", res.RetryAfter)
+	}
+
+	if _, err := l.Allow(ctx, "nope", "user:1"); err == nil {
+		t.Error("Allow with an unknown policy succeeded")
+	}
+}
+
+func TestNewRejectsBadPolicy(t *testing.T) {
+	if _, err := New(NewMemory(), map[string]Policy{"bad": {Limit: 1, Burst: 1}}); err == nil {
+		t.Error("New accepted a policy without a period")
+	}
+}
@@ -0,0 +1,302 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Memory is an in-process Store for tests and local experiments. It enforces
+// the same constraints as the Postgres schema: unique emails, foreign keys
+// from chirps and refresh tokens to users, and cascading deletes.
+type Memory struct {
+	txMu   sync.Mutex
+	mu     sync.RWMutex
+	users  map[uuid.UUID]database.User
+	chirps []database.Chirp
+	tokens map[string]database.RefreshToken
+	now    func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:  map[uuid.UUID]database.User{},
+		tokens: map[string]database.RefreshToken{},
+		now:    time.Now,
+	}
+}
+
+var _ Store = (*Memory)(nil)
+
+// timestamp mimics a Postgres TIMESTAMP column, which keeps microsecond
+// precision and no time zone.
+func timestamp(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// WithTx runs transactions one at a time. If fn fails, the store is put back
+// the way it was when the transaction began, which also discards writes made
+// outside any transaction in the meantime; that is acceptable for a store
+// meant for tests.
+func (m *Memory) WithTx(ctx context.Context, fn TxFunc) error {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	m.mu.RLock()
+	users, chirps, tokens := maps.Clone(m.users), slices.Clone(m.chirps), maps.Clone(m.tokens)
+	m.mu.RUnlock()
+
+	if err := fn(m); err != nil {
+		m.mu.Lock()
+		m.users, m.chirps, m.tokens = users, chirps, tokens
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (m *Memory) ClearUsers(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.users)
+	clear(m.tokens)
+	m.chirps = nil
+	return nil
+}
+
+func (m *Memory) emailTaken(email string, except uuid.UUID) bool {
+	for _, u := range m.users {
+		if u.Email == email && u.ID != except {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.ID]; ok || m.emailTaken(arg.Email, uuid.Nil) {
+		return database.User{}, ErrUniqueViolation
+	}
+	u := database.User{
+		ID:             arg.ID,
+		CreatedAt:      timestamp(arg.CreatedAt),
+		UpdatedAt:      timestamp(arg.UpdatedAt),
+		Email:          arg.Email,
+		HashedPassword: arg.HashedPassword,
+	}
+	m.users[u.ID] = u
+	return u, nil
+}
+
+func (m *Memory) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return database.User{}, sql.ErrNoRows
+}
+
+// The *ForUpdate queries need no row locks: WithTx already serializes
+// transactions.
+func (m *Memory) GetUserByIDForUpdate(ctx context.Context, id uuid.UUID) (database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.users[id]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[arg.ID]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+	if m.emailTaken(arg.Email, arg.ID) {
+		return database.User{}, ErrUniqueViolation
+	}
+	if u.Email != arg.Email {
+		u.EmailVerifiedAt = sql.NullTime{}
+	}
+	u.Email = arg.Email
+	u.HashedPassword = arg.HashedPassword
+	u.UpdatedAt = timestamp(m.now())
+	m.users[u.ID] = u
+	return u, nil
+}
+
+func (m *Memory) UpdateUserToRed(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Like the UPDATE it stands in for, a missing user is not an error.
+	if u, ok := m.users[id]; ok {
+		u.IsChirpyRed = true
+		m.users[id] = u
+	}
+	return nil
+}
+
+func (m *Memory) VerifyUserEmail(ctx context.Context, arg database.VerifyUserEmailParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[arg.ID]
+	if !ok || u.Email != arg.Email {
+		return database.User{}, sql.ErrNoRows
+	}
+	now := timestamp(m.now())
+	if !u.EmailVerifiedAt.Valid {
+		u.EmailVerifiedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	u.UpdatedAt = now
+	m.users[u.ID] = u
+	return u, nil
+}
+
+func (m *Memory) CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.Chirp{}, ErrForeignKeyViolation
+	}
+	for _, c := range m.chirps {
+		if c.ID == arg.ID {
+			return database.Chirp{}, ErrUniqueViolation
+		}
+	}
+	c := database.Chirp{
+		ID:        arg.ID,
+		CreatedAt: timestamp(arg.CreatedAt),
+		UpdatedAt: timestamp(arg.UpdatedAt),
+		Body:      arg.Body,
+		UserID:    arg.UserID,
+	}
+	m.chirps = append(m.chirps, c)
+	return c, nil
+}
+
+func (m *Memory) GetChirps(ctx context.Context) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return sortedChirps(m.chirps, func(database.Chirp) bool { return true }), nil
+}
+
+func (m *Memory) GetChirpsByAuthID(ctx context.Context, userID uuid.UUID) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return sortedChirps(m.chirps, func(c database.Chirp) bool { return c.UserID == userID }), nil
+}
+
+func sortedChirps(chirps []database.Chirp, keep func(database.Chirp) bool) []database.Chirp {
+	var out []database.Chirp
+	for _, c := range chirps {
+		if keep(c) {
+			out = append(out, c)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b database.Chirp) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return out
+}
+
+func (m *Memory) GetChirpById(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, c := range m.chirps {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return database.Chirp{}, sql.ErrNoRows
+}
+
+func (m *Memory) GetChirpByIdForUpdate(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	return m.GetChirpById(ctx, id)
+}
+
+func (m *Memory) DeleteChirpByID(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chirps = slices.DeleteFunc(m.chirps, func(c database.Chirp) bool { return c.ID == id })
+	return nil
+}
+
+func (m *Memory) DeleteStaleTokens(ctx context.Context, arg database.DeleteStaleTokensParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for token, t := range m.tokens {
+		if n == int64(arg.BatchSize) {
+			break
+		}
+		if t.ExpiresAt.Before(arg.ExpiredBefore) || (t.RevokedAt.Valid && t.RevokedAt.Time.Before(arg.RevokedBefore)) {
+			delete(m.tokens, token)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) CreateToken(ctx context.Context, arg database.CreateTokenParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return ErrForeignKeyViolation
+	}
+	if _, ok := m.tokens[arg.Token]; ok {
+		return ErrUniqueViolation
+	}
+	t := database.RefreshToken{
+		Token:     arg.Token,
+		CreatedAt: timestamp(arg.CreatedAt),
+		UpdatedAt: timestamp(arg.UpdatedAt),
+		UserID:    arg.UserID,
+		ExpiresAt: timestamp(arg.ExpiresAt),
+		RevokedAt: arg.RevokedAt,
+	}
+	if t.RevokedAt.Valid {
+		t.RevokedAt.Time = timestamp(t.RevokedAt.Time)
+	}
+	m.tokens[t.Token] = t
+	return nil
+}
+
+func (m *Memory) GetTokenByID(ctx context.Context, token string) (database.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.tokens[token]
+	if !ok || t.RevokedAt.Valid {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+func (m *Memory) RevokeToken(ctx context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.tokens[token]; ok {
+		now := timestamp(m.now())
+		t.RevokedAt = sql.NullTime{Time: now, Valid: true}
+		t.UpdatedAt = now
+		m.tokens[token] = t
+	}
+	return nil
+}
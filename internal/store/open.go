@@ -0,0 +1,96 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// Backend identifies the database engine behind a Store.
+type Backend string
+
+const (
+	Postgres Backend = "postgres"
+	SQLite   Backend = "sqlite"
+)
+
+// Conn is an open database together with the Store that uses it.
+type Conn struct {
+	DB      *sql.DB
+	Store   Store
+	Backend Backend
+}
+
+// BackendFor picks the backend from the scheme of dbURL: postgres:// or
+// postgresql:// for Postgres, sqlite: for SQLite.
+func BackendFor(dbURL string) (Backend, error) {
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid database URL: %w", err)
+	}
+	switch u.Scheme {
+	case "postgres", "postgresql":
+		return Postgres, nil
+	case "sqlite":
+		return SQLite, nil
+	default:
+		return "", fmt.Errorf("unsupported database URL scheme %q", u.Scheme)
+	}
+}
+
+// Open connects to dbURL. SQLite URLs name a file, relative or absolute:
+// sqlite:chirpy.db or sqlite:///var/lib/chirpy/chirpy.db.
+func Open(dbURL string) (*Conn, error) {
+	backend, err := BackendFor(dbURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch backend {
+	case SQLite:
+		db, err := sql.Open("sqlite", sqliteDSN(dbURL))
+		if err != nil {
+			return nil, err
+		}
+		// SQLite allows a single writer; one connection avoids SQLITE_BUSY
+		// between our own goroutines.
+		db.SetMaxOpenConns(1)
+		return &Conn{DB: db, Store: NewSQLite(db), Backend: SQLite}, nil
+	default:
+		db, err := sql.Open("postgres", dbURL)
+		if err != nil {
+			return nil, err
+		}
+		return &Conn{DB: db, Store: NewPostgres(db), Backend: Postgres}, nil
+	}
+}
+
+// sqliteDSN turns a sqlite: URL into a modernc.org/sqlite DSN with foreign
+// keys enforced and times written in a format that sorts correctly.
+func sqliteDSN(dbURL string) string {
+	path := strings.TrimPrefix(dbURL, "sqlite:")
+	if strings.HasPrefix(path, "///") {
+		path = path[2:]
+	} else {
+		path = strings.TrimPrefix(path, "//")
+	}
+
+	query := ""
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+	params := url.Values{}
+	if query != "" {
+		params, _ = url.ParseQuery(query)
+	}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Set("_time_format", "sqlite")
+
+	return "file:" + path + "?" + params.Encode()
+}
@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/05blue04/chirpy/internal/database"
+	"github.com/05blue04/chirpy/internal/database/sqlitedb"
+	"github.com/google/uuid"
+)
+
+// sqlite adapts the SQLite query set, which sqlc generates into its own
+// package, to the Store interface. The generated types mirror the Postgres
+// ones field for field, so they convert directly.
+type sqlite struct {
+	q  *sqlitedb.Queries
+	db *sql.DB
+}
+
+// NewSQLite returns a Store backed by the SQLite query set. db must have
+// foreign keys enabled; Open takes care of that.
+func NewSQLite(db *sql.DB) Store {
+	return &sqlite{
+		q:  sqlitedb.New(database.NewTracedDB(db, "sqlite")),
+		db: db,
+	}
+}
+
+func (s *sqlite) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlite) WithTx(ctx context.Context, fn TxFunc) error {
+	return runTx(ctx, s.db, func(tx *sql.Tx) database.Querier {
+		return &sqlite{q: sqlitedb.New(database.NewTracedDB(tx, "sqlite")), db: s.db}
+	}, fn)
+}
+
+func (s *sqlite) ClearUsers(ctx context.Context) error {
+	return s.q.ClearUsers(ctx)
+}
+
+func (s *sqlite) CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error) {
+	arg.CreatedAt = arg.CreatedAt.UTC()
+	arg.UpdatedAt = arg.UpdatedAt.UTC()
+	c, err := s.q.CreateChirp(ctx, sqlitedb.CreateChirpParams(arg))
+	return database.Chirp(c), err
+}
+
+func (s *sqlite) CreateToken(ctx context.Context, arg database.CreateTokenParams) error {
+	arg.CreatedAt = arg.CreatedAt.UTC()
+	arg.UpdatedAt = arg.UpdatedAt.UTC()
+	arg.ExpiresAt = arg.ExpiresAt.UTC()
+	arg.RevokedAt.Time = arg.RevokedAt.Time.UTC()
+	return s.q.CreateToken(ctx, sqlitedb.CreateTokenParams(arg))
+}
+
+func (s *sqlite) CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+	arg.CreatedAt = arg.CreatedAt.UTC()
+	arg.UpdatedAt = arg.UpdatedAt.UTC()
+	u, err := s.q.CreateUser(ctx, sqlitedb.CreateUserParams(arg))
+	return database.User(u), err
+}
+
+func (s *sqlite) DeleteChirpByID(ctx context.Context, id uuid.UUID) error {
+	return s.q.DeleteChirpByID(ctx, id)
+}
+
+func (s *sqlite) DeleteStaleTokens(ctx context.Context, arg database.DeleteStaleTokensParams) (int64, error) {
+	return s.q.DeleteStaleTokens(ctx, sqlitedb.DeleteStaleTokensParams{
+		ExpiredBefore: arg.ExpiredBefore.UTC(),
+		RevokedBefore: sql.NullTime{Time: arg.RevokedBefore.UTC(), Valid: true},
+		BatchSize:     int64(arg.BatchSize),
+	})
+}
+
+func (s *sqlite) GetChirpById(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	c, err := s.q.GetChirpById(ctx, id)
+	return database.Chirp(c), err
+}
+
+func (s *sqlite) GetChirpByIdForUpdate(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	c, err := s.q.GetChirpByIdForUpdate(ctx, id)
+	return database.Chirp(c), err
+}
+
+func (s *sqlite) GetChirps(ctx context.Context) ([]database.Chirp, error) {
+	chirps, err := s.q.GetChirps(ctx)
+	return convertChirps(chirps), err
+}
+
+func (s *sqlite) GetChirpsByAuthID(ctx context.Context, userID uuid.UUID) ([]database.Chirp, error) {
+	chirps, err := s.q.GetChirpsByAuthID(ctx, userID)
+	return convertChirps(chirps), err
+}
+
+func convertChirps(chirps []sqlitedb.Chirp) []database.Chirp {
+	if chirps == nil {
+		return nil
+	}
+	out := make([]database.Chirp, len(chirps))
+	for i, c := range chirps {
+		out[i] = database.Chirp(c)
+	}
+	return out
+}
+
+func (s *sqlite) GetTokenByID(ctx context.Context, token string) (database.RefreshToken, error) {
+	t, err := s.q.GetTokenByID(ctx, token)
+	return database.RefreshToken(t), err
+}
+
+func (s *sqlite) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
+	u, err := s.q.GetUserByEmail(ctx, email)
+	return database.User(u), err
+}
+
+func (s *sqlite) GetUserByIDForUpdate(ctx context.Context, id uuid.UUID) (database.User, error) {
+	u, err := s.q.GetUserByIDForUpdate(ctx, id)
+	return database.User(u), err
+}
+
+func (s *sqlite) RevokeToken(ctx context.Context, token string) error {
+	return s.q.RevokeToken(ctx, token)
+}
+
+func (s *sqlite) UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+	u, err := s.q.UpdateUser(ctx, sqlitedb.UpdateUserParams(arg))
+	return database.User(u), err
+}
+
+func (s *sqlite) UpdateUserToRed(ctx context.Context, id uuid.UUID) error {
+	return s.q.UpdateUserToRed(ctx, id)
+}
+
+func (s *sqlite) VerifyUserEmail(ctx context.Context, arg database.VerifyUserEmailParams) (database.User, error) {
+	u, err := s.q.VerifyUserEmail(ctx, sqlitedb.VerifyUserEmailParams(arg))
+	return database.User(u), err
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/05blue04/chirpy/internal/database"
+	"github.com/lib/pq"
+	modernc "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Store is the persistence layer behind the HTTP handlers. Every query
+// generated by sqlc is part of it, so the Postgres implementation is just the
+// generated code. The SQLite implementation adapts its own generated query
+// set, and Memory provides the same semantics without a database.
+//
+// Lookups that find nothing return sql.ErrNoRows, as the generated code
+// does.
+type Store interface {
+	database.Querier
+	Ping(ctx context.Context) error
+	// WithTx runs fn in a transaction that commits if fn returns nil and
+	// rolls back otherwise. The *ForUpdate queries lock the rows they read
+	// until the transaction ends. Serialization failures and deadlocks are
+	// retried a few times before their error is returned.
+	WithTx(ctx context.Context, fn TxFunc) error
+}
+
+var (
+	// ErrUniqueViolation is returned when a write would duplicate a unique
+	// value such as a user's email.
+	ErrUniqueViolation = errors.New("store: unique constraint violation")
+	// ErrForeignKeyViolation is returned when a write references a row that
+	// does not exist.
+	ErrForeignKeyViolation = errors.New("store: foreign key violation")
+)
+
+// IsUniqueViolation reports whether err is a unique constraint violation
+// from any Store implementation.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var liteErr *modernc.Error
+	if errors.As(err, &liteErr) {
+		return liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE ||
+			liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	return errors.Is(err, ErrUniqueViolation)
+}
+
+// IsForeignKeyViolation reports whether err is a foreign key violation from
+// any Store implementation.
+func IsForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23503"
+	}
+	var liteErr *modernc.Error
+	if errors.As(err, &liteErr) {
+		return liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+	}
+	return errors.Is(err, ErrForeignKeyViolation)
+}
+
+type postgres struct {
+	*database.Queries
+	db *sql.DB
+}
+
+// NewPostgres returns a Store backed by the sqlc queries. Queries run through
+// a tracing wrapper around db.
+func NewPostgres(db *sql.DB) Store {
+	return &postgres{
+		Queries: database.New(database.NewTracedDB(db, "postgresql")),
+		db:      db,
+	}
+}
+
+func (p *postgres) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+func (p *postgres) WithTx(ctx context.Context, fn TxFunc) error {
+	return runTx(ctx, p.db, func(tx *sql.Tx) database.Querier {
+		// Queries.WithTx would bind the bare transaction and lose tracing.
+		return database.New(database.NewTracedDB(tx, "postgresql"))
+	}, fn)
+}
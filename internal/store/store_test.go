@@ -0,0 +1,60 @@
+package store_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/05blue04/chirpy/internal/migrate"
+	"github.com/05blue04/chirpy/internal/store"
+	"github.com/05blue04/chirpy/internal/store/storetest"
+)
+
+func TestMemory(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewMemory()
+	})
+}
+
+func TestSQLite(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		path := filepath.Join(t.TempDir(), "chirpy.db")
+		return openMigrated(t, "sqlite:"+path)
+	})
+}
+
+// TestPostgres runs against CHIRPY_TEST_POSTGRES_URL when it is set. The
+// database is migrated and its users cleared before every subtest, so point
+// it at a throwaway database.
+func TestPostgres(t *testing.T) {
+	dbURL := os.Getenv("CHIRPY_TEST_POSTGRES_URL")
+	if dbURL == "" {
+		t.Skip("CHIRPY_TEST_POSTGRES_URL not set")
+	}
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s := openMigrated(t, dbURL)
+		if err := s.ClearUsers(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func openMigrated(t *testing.T, dbURL string) store.Store {
+	t.Helper()
+	conn, err := store.Open(dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.DB.Close() })
+
+	m, err := migrate.New(conn.DB, conn.Backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	return conn.Store
+}
@@ -0,0 +1,510 @@
+// Package storetest is a conformance suite that every store.Store
+// implementation must pass, so the backends stay interchangeable.
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/database"
+	"github.com/05blue04/chirpy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Run exercises a Store. newStore must return an empty store; it is called
+// once per subtest.
+func Run(t *testing.T, newStore func(t *testing.T) store.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s store.Store)
+	}{
+		{"Ping", testPing},
+		{"UniqueEmail", testUniqueEmail},
+		{"UpdateUser", testUpdateUser},
+		{"UpdateUserToRed", testUpdateUserToRed},
+		{"VerifyUserEmail", testVerifyUserEmail},
+		{"ForeignKeys", testForeignKeys},
+		{"ClearUsersCascades", testClearUsersCascades},
+		{"RevokedTokenHidden", testRevokedTokenHidden},
+		{"DeleteStaleTokens", testDeleteStaleTokens},
+		{"ChirpOrder", testChirpOrder},
+		{"DeleteChirp", testDeleteChirp},
+		{"NotFound", testNotFound},
+		{"ForUpdate", testForUpdate},
+		{"TxCommit", testTxCommit},
+		{"TxRollback", testTxRollback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func createUser(t *testing.T, s database.Querier, email string) database.User {
+	t.Helper()
+	now := time.Now()
+	u, err := s.CreateUser(context.Background(), database.CreateUserParams{
+		ID:             uuid.New(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Email:          email,
+		HashedPassword: "hash",
+	})
+	if err != nil {
+		t.Fatalf("CreateUser(%q) error = %v", email, err)
+	}
+	return u
+}
+
+func createChirp(t *testing.T, s database.Querier, userID uuid.UUID, createdAt time.Time) database.Chirp {
+	t.Helper()
+	c, err := s.CreateChirp(context.Background(), database.CreateChirpParams{
+		ID:        uuid.New(),
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+		Body:      "chirp",
+		UserID:    userID,
+	})
+	if err != nil {
+		t.Fatalf("CreateChirp error = %v", err)
+	}
+	return c
+}
+
+func createToken(t *testing.T, s database.Querier, token string, userID uuid.UUID) {
+	t.Helper()
+	now := time.Now()
+	err := s.CreateToken(context.Background(), database.CreateTokenParams{
+		Token:     token,
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    userID,
+		ExpiresAt: now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateToken error = %v", err)
+	}
+}
+
+func testPing(t *testing.T, s store.Store) {
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping() error = %v", err)
+	}
+}
+
+func testUniqueEmail(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	createUser(t, s, "a@example.com")
+	b := createUser(t, s, "b@example.com")
+
+	_, err := s.CreateUser(ctx, database.CreateUserParams{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Email:     "a@example.com",
+	})
+	if !store.IsUniqueViolation(err) {
+		t.Errorf("CreateUser duplicate email error = %v, want unique violation", err)
+	}
+
+	_, err = s.UpdateUser(ctx, database.UpdateUserParams{ID: b.ID, Email: "a@example.com"})
+	if !store.IsUniqueViolation(err) {
+		t.Errorf("UpdateUser to taken email error = %v, want unique violation", err)
+	}
+}
+
+func testUpdateUser(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := createUser(t, s, "a@example.com")
+
+	updated, err := s.UpdateUser(ctx, database.UpdateUserParams{
+		ID:             u.ID,
+		Email:          "new@example.com",
+		HashedPassword: "new-hash",
+	})
+	if err != nil {
+		t.Fatalf("UpdateUser error = %v", err)
+	}
+	if updated.Email != "new@example.com" || updated.HashedPassword != "new-hash" {
+		t.Errorf("UpdateUser returned %+v", updated)
+	}
+	if !updated.CreatedAt.Equal(u.CreatedAt) {
+		t.Errorf("UpdateUser changed created_at from %v to %v", u.CreatedAt, updated.CreatedAt)
+	}
+
+	got, err := s.GetUserByEmail(ctx, "new@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail after update error = %v", err)
+	}
+	if got.ID != u.ID {
+		t.Errorf("GetUserByEmail returned user %s, want %s", got.ID, u.ID)
+	}
+	if _, err := s.GetUserByEmail(ctx, "a@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByEmail old email error = %v, want sql.ErrNoRows", err)
+	}
+
+	_, err = s.UpdateUser(ctx, database.UpdateUserParams{ID: uuid.New(), Email: "x@example.com"})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("UpdateUser missing user error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testUpdateUserToRed(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := createUser(t, s, "a@example.com")
+	if u.IsChirpyRed {
+		t.Fatal("new user is already Chirpy Red")
+	}
+
+	if err := s.UpdateUserToRed(ctx, u.ID); err != nil {
+		t.Fatalf("UpdateUserToRed error = %v", err)
+	}
+	got, err := s.GetUserByEmail(ctx, u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsChirpyRed {
+		t.Error("user is not Chirpy Red after UpdateUserToRed")
+	}
+
+	// An UPDATE matching no rows is not an error.
+	if err := s.UpdateUserToRed(ctx, uuid.New()); err != nil {
+		t.Errorf("UpdateUserToRed missing user error = %v, want nil", err)
+	}
+}
+
+func testVerifyUserEmail(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := createUser(t, s, "a@example.com")
+	if u.EmailVerifiedAt.Valid {
+		t.Fatalf("new user is verified: %+v", u)
+	}
+
+	if _, err := s.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: u.ID, Email: "b@example.com"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("VerifyUserEmail for another address error = %v, want sql.ErrNoRows", err)
+	}
+	v, err := s.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: u.ID, Email: u.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.EmailVerifiedAt.Valid {
+		t.Fatalf("VerifyUserEmail returned %+v", v)
+	}
+	again, err := s.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: u.ID, Email: u.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.EmailVerifiedAt.Time.Equal(v.EmailVerifiedAt.Time) {
+		t.Errorf("verifying again moved EmailVerifiedAt from %v to %v", v.EmailVerifiedAt.Time, again.EmailVerifiedAt.Time)
+	}
+
+	// Keeping the address keeps it verified; changing it does not.
+	same, err := s.UpdateUser(ctx, database.UpdateUserParams{Email: u.Email, HashedPassword: "new", ID: u.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same.EmailVerifiedAt.Valid {
+		t.Error("UpdateUser with the same email cleared EmailVerifiedAt")
+	}
+	changed, err := s.UpdateUser(ctx, database.UpdateUserParams{Email: "b@example.com", HashedPassword: "new", ID: u.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.EmailVerifiedAt.Valid {
+		t.Error("UpdateUser with a new email kept EmailVerifiedAt")
+	}
+}
+
+func testForeignKeys(t *testing.T, s store.Store) {
+	ctx := context.Background()
+
+	_, err := s.CreateChirp(ctx, database.CreateChirpParams{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Body:      "hi",
+		UserID:    uuid.New(),
+	})
+	if !store.IsForeignKeyViolation(err) {
+		t.Errorf("CreateChirp for missing user error = %v, want foreign key violation", err)
+	}
+
+	err = s.CreateToken(ctx, database.CreateTokenParams{
+		Token:     "t",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		UserID:    uuid.New(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if !store.IsForeignKeyViolation(err) {
+		t.Errorf("CreateToken for missing user error = %v, want foreign key violation", err)
+	}
+}
+
+func testClearUsersCascades(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := createUser(t, s, "a@example.com")
+	c := createChirp(t, s, u.ID, time.Now())
+	createToken(t, s, "t", u.ID)
+
+	if err := s.ClearUsers(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetUserByEmail(ctx, u.Email); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByEmail after ClearUsers error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := s.GetChirpById(ctx, c.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetChirpById after ClearUsers error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := s.GetTokenByID(ctx, "t"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetTokenByID after ClearUsers error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testRevokedTokenHidden(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := createUser(t, s, "a@example.com")
+	createToken(t, s, "t", u.ID)
+
+	got, err := s.GetTokenByID(ctx, "t")
+	if err != nil {
+		t.Fatalf("GetTokenByID error = %v", err)
+	}
+	if got.UserID != u.ID || got.RevokedAt.Valid {
+		t.Errorf("GetTokenByID returned %+v", got)
+	}
+
+	if err := s.RevokeToken(ctx, "t"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetTokenByID(ctx, "t"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetTokenByID after revoke error = %v, want sql.ErrNoRows", err)
+	}
+
+	if err := s.RevokeToken(ctx, "missing"); err != nil {
+		t.Errorf("RevokeToken missing token error = %v, want nil", err)
+	}
+}
+
+func testDeleteStaleTokens(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := createUser(t, s, "a@example.com")
+	now := time.Now()
+	tokens := []struct {
+		token     string
+		expiresAt time.Time
+		revokedAt time.Time
+	}{
+		{token: "live", expiresAt: now.Add(time.Hour)},
+		{token: "expired", expiresAt: now.Add(-time.Hour)},
+		{token: "revoked-recently", expiresAt: now.Add(time.Hour), revokedAt: now.Add(-time.Minute)},
+		{token: "revoked-long-ago", expiresAt: now.Add(time.Hour), revokedAt: now.Add(-48 * time.Hour)},
+	}
+	for _, tok := range tokens {
+		err := s.CreateToken(ctx, database.CreateTokenParams{
+			Token:     tok.token,
+			CreatedAt: now.Add(-72 * time.Hour),
+			UpdatedAt: now.Add(-72 * time.Hour),
+			UserID:    u.ID,
+			ExpiresAt: tok.expiresAt,
+			RevokedAt: sql.NullTime{Time: tok.revokedAt, Valid: !tok.revokedAt.IsZero()},
+		})
+		if err != nil {
+			t.Fatalf("CreateToken(%q) error = %v", tok.token, err)
+		}
+	}
+
+	arg := database.DeleteStaleTokensParams{
+		ExpiredBefore: now,
+		RevokedBefore: now.Add(-24 * time.Hour),
+		BatchSize:     1,
+	}
+	for i, want := range []int64{1, 1, 0} {
+		n, err := s.DeleteStaleTokens(ctx, arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("batch %d deleted %d tokens, want %d", i, n, want)
+		}
+	}
+	if _, err := s.GetTokenByID(ctx, "live"); err != nil {
+		t.Errorf("GetTokenByID(live) error = %v", err)
+	}
+
+	// The recently revoked token was kept; a shorter retention takes it.
+	arg.RevokedBefore = now
+	arg.BatchSize = 10
+	if n, err := s.DeleteStaleTokens(ctx, arg); err != nil || n != 1 {
+		t.Errorf("DeleteStaleTokens = %d, %v, want 1", n, err)
+	}
+}
+
+func testChirpOrder(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	a := createUser(t, s, "a@example.com")
+	b := createUser(t, s, "b@example.com")
+
+	base := time.Now()
+	createChirp(t, s, a.ID, base)
+	createChirp(t, s, b.ID, base.Add(-time.Minute))
+	createChirp(t, s, a.ID, base.Add(-2*time.Minute))
+
+	all, err := s.GetChirps(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("GetChirps returned %d chirps, want 3", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].CreatedAt.Before(all[i-1].CreatedAt) {
+			t.Errorf("GetChirps not in ascending created_at order: %v before %v", all[i-1].CreatedAt, all[i].CreatedAt)
+		}
+	}
+
+	byA, err := s.GetChirpsByAuthID(ctx, a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byA) != 2 {
+		t.Fatalf("GetChirpsByAuthID returned %d chirps, want 2", len(byA))
+	}
+	if byA[0].CreatedAt.After(byA[1].CreatedAt) {
+		t.Error("GetChirpsByAuthID not in ascending created_at order")
+	}
+	for _, c := range byA {
+		if c.UserID != a.ID {
+			t.Errorf("GetChirpsByAuthID returned chirp by %s", c.UserID)
+		}
+	}
+}
+
+func testDeleteChirp(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := createUser(t, s, "a@example.com")
+	c := createChirp(t, s, u.ID, time.Now())
+
+	got, err := s.GetChirpById(ctx, c.ID)
+	if err != nil {
+		t.Fatalf("GetChirpById error = %v", err)
+	}
+	if got.Body != c.Body || got.UserID != u.ID {
+		t.Errorf("GetChirpById returned %+v, want %+v", got, c)
+	}
+
+	if err := s.DeleteChirpByID(ctx, c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetChirpById(ctx, c.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetChirpById after delete error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testNotFound(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if _, err := s.GetUserByEmail(ctx, "nobody@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByEmail error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := s.GetChirpById(ctx, uuid.New()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetChirpById error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := s.GetTokenByID(ctx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetTokenByID error = %v, want sql.ErrNoRows", err)
+	}
+	chirps, err := s.GetChirps(ctx)
+	if err != nil || len(chirps) != 0 {
+		t.Errorf("GetChirps on empty store = %v, %v", chirps, err)
+	}
+}
+
+func testForUpdate(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := createUser(t, s, "a@example.com")
+	c := createChirp(t, s, u.ID, time.Now())
+
+	err := s.WithTx(ctx, func(q database.Querier) error {
+		gotUser, err := q.GetUserByIDForUpdate(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+		if gotUser.Email != u.Email {
+			t.Errorf("GetUserByIDForUpdate returned %+v, want %+v", gotUser, u)
+		}
+		gotChirp, err := q.GetChirpByIdForUpdate(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+		if gotChirp.Body != c.Body {
+			t.Errorf("GetChirpByIdForUpdate returned %+v, want %+v", gotChirp, c)
+		}
+
+		if _, err := q.GetUserByIDForUpdate(ctx, uuid.New()); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetUserByIDForUpdate missing user error = %v, want sql.ErrNoRows", err)
+		}
+		if _, err := q.GetChirpByIdForUpdate(ctx, uuid.New()); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetChirpByIdForUpdate missing chirp error = %v, want sql.ErrNoRows", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithTx error = %v", err)
+	}
+}
+
+func testTxCommit(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := createUser(t, s, "a@example.com")
+	c := createChirp(t, s, u.ID, time.Now())
+
+	err := s.WithTx(ctx, func(q database.Querier) error {
+		if err := q.DeleteChirpByID(ctx, c.ID); err != nil {
+			return err
+		}
+		return q.UpdateUserToRed(ctx, u.ID)
+	})
+	if err != nil {
+		t.Fatalf("WithTx error = %v", err)
+	}
+
+	if _, err := s.GetChirpById(ctx, c.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetChirpById after committed delete error = %v, want sql.ErrNoRows", err)
+	}
+	got, err := s.GetUserByEmail(ctx, u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsChirpyRed {
+		t.Error("committed upgrade is missing")
+	}
+}
+
+func testTxRollback(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	u := createUser(t, s, "a@example.com")
+	c := createChirp(t, s, u.ID, time.Now())
+
+	errAbort := errors.New("abort")
+	err := s.WithTx(ctx, func(q database.Querier) error {
+		if err := q.DeleteChirpByID(ctx, c.ID); err != nil {
+			return err
+		}
+		createUser(t, q, "b@example.com")
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithTx error = %v, want %v", err, errAbort)
+	}
+
+	if _, err := s.GetChirpById(ctx, c.ID); err != nil {
+		t.Errorf("GetChirpById after rolled back delete error = %v", err)
+	}
+	if _, err := s.GetUserByEmail(ctx, "b@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByEmail for rolled back user error = %v, want sql.ErrNoRows", err)
+	}
+}
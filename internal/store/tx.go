@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/database"
+	"github.com/lib/pq"
+	modernc "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// TxFunc is the body of a transaction. All of its queries must go through q;
+// using the Store directly would run outside the transaction, and on SQLite
+// would wait forever for the connection the transaction holds. It may run
+// more than once when the transaction is retried, so it should not have side
+// effects outside q.
+type TxFunc func(q database.Querier) error
+
+// maxTxAttempts bounds how often a transaction is retried after a
+// serialization failure or deadlock.
+const maxTxAttempts = 3
+
+// retryTx runs attempt until it succeeds, fails with an error that retrying
+// will not fix, or maxTxAttempts is reached. It waits a short, jittered,
+// exponentially growing delay between attempts.
+func retryTx(ctx context.Context, attempt func() error) error {
+	var err error
+	for i := range maxTxAttempts {
+		if i > 0 {
+			backoff := time.Duration(5<<i) * time.Millisecond
+			backoff += rand.N(backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return errors.Join(err, ctx.Err())
+			}
+		}
+		err = attempt()
+		if !isRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// isRetryable reports whether err means the transaction lost a race with
+// another one and can simply be run again.
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// serialization_failure, deadlock_detected
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	var liteErr *modernc.Error
+	if errors.As(err, &liteErr) {
+		return liteErr.Code()&0xff == sqlite3.SQLITE_BUSY
+	}
+	return false
+}
+
+// runTx runs fn inside a database transaction on db, handing it the query set
+// that bind returns for the transaction. The transaction is rolled back if fn
+// fails and retried as a whole on serialization failures.
+func runTx(ctx context.Context, db *sql.DB, bind func(tx *sql.Tx) database.Querier, fn TxFunc) error {
+	return retryTx(ctx, func() error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(bind(tx)); err != nil {
+			return errors.Join(err, ignoreDone(tx.Rollback()))
+		}
+		return tx.Commit()
+	})
+}
+
+// ignoreDone drops sql.ErrTxDone, which Rollback returns when the context
+// has already rolled the transaction back.
+func ignoreDone(err error) error {
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestRetryTx(t *testing.T) {
+	serialization := &pq.Error{Code: "40001"}
+	other := errors.New("boom")
+
+	tests := []struct {
+		name     string
+		errs     []error
+		wantErr  error
+		attempts int
+	}{
+		{"success", []error{nil}, nil, 1},
+		{"retried then success", []error{serialization, nil}, nil, 2},
+		{"deadlock retried", []error{&pq.Error{Code: "40P01"}, nil}, nil, 2},
+		{"other error not retried", []error{other}, other, 1},
+		{"gives up", []error{serialization, serialization, serialization, nil}, serialization, maxTxAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := retryTx(context.Background(), func() error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("retryTx() error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryTxStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	err := retryTx(ctx, func() error {
+		attempts++
+		cancel()
+		return &pq.Error{Code: "40001"}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("retryTx() error = %v, want context.Canceled", err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
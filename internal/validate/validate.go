@@ -0,0 +1,146 @@
+// Package validate checks struct fields against rules declared in
+// `validate` struct tags:
+//
+//	type params struct {
+//		Email string `json:"email" validate:"required,email,max=254"`
+//	}
+//
+// The rules are:
+//
+//	required   the field is not its zero value
+//	email      a bare address such as walt@example.com
+//	uuid       a string holding a UUID
+//	min=N      a string of at least N characters
+//	max=N      a string of at most N characters
+//	oneof=a|b  a string equal to one of the listed values
+//
+// Rules other than required pass on an empty string, so optional fields can
+// still be constrained. Fields are reported by their JSON names, and nested
+// structs by a dotted path such as data.user_id.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// FieldError describes one field that failed one rule.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors lists every field that failed validation.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + " " + fe.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Struct checks v, a struct or pointer to one, and returns Errors listing
+// each field that broke a rule, or nil. Only the first rule a field breaks is
+// reported. An unknown rule is a programming error and panics.
+func Struct(v any) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validate: Struct called with %T", v))
+	}
+	var errs Errors
+	check(rv, "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func check(rv reflect.Value, prefix string, errs *Errors) {
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		sf := rt.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := prefix + jsonName(sf)
+		fv := rv.Field(i)
+
+		if rules := sf.Tag.Get("validate"); rules != "" {
+			for _, rule := range strings.Split(rules, ",") {
+				if fe, failed := apply(rule, fv); failed {
+					fe.Field = name
+					*errs = append(*errs, fe)
+					break
+				}
+			}
+		}
+		if fv.Kind() == reflect.Struct {
+			check(fv, name+".", errs)
+		}
+	}
+}
+
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+// apply reports whether fv breaks rule, and how.
+func apply(rule string, fv reflect.Value) (FieldError, bool) {
+	name, arg, _ := strings.Cut(rule, "=")
+	if name == "required" {
+		return FieldError{Rule: name, Message: "is required"}, fv.IsZero()
+	}
+
+	if fv.Kind() != reflect.String {
+		panic(fmt.Sprintf("validate: rule %q on non-string field of type %s", rule, fv.Type()))
+	}
+	s := fv.String()
+	if s == "" {
+		return FieldError{}, false
+	}
+
+	switch name {
+	case "email":
+		addr, err := mail.ParseAddress(s)
+		return FieldError{Rule: name, Message: "must be a valid email address"}, err != nil || addr.Address != s
+	case "uuid":
+		return FieldError{Rule: name, Message: "must be a UUID"}, uuid.Validate(s) != nil
+	case "min":
+		n := atoi(rule, arg)
+		return FieldError{Rule: name, Message: fmt.Sprintf("must be at least %d characters", n)}, utf8.RuneCountInString(s) < n
+	case "max":
+		n := atoi(rule, arg)
+		return FieldError{Rule: name, Message: fmt.Sprintf("must be at most %d characters", n)}, utf8.RuneCountInString(s) > n
+	case "oneof":
+		options := strings.Split(arg, "|")
+		for _, o := range options {
+			if s == o {
+				return FieldError{}, false
+			}
+		}
+		return FieldError{Rule: name, Message: "must be one of " + strings.Join(options, ", ")}, true
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q", rule))
+	}
+}
+
+func atoi(rule, arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		panic(fmt.Sprintf("validate: rule %q needs an integer", rule))
+	}
+	return n
+}
@@ -0,0 +1,121 @@
+package validate
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type signup struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,min=4,max=72"`
+	Plan     string `json:"plan" validate:"oneof=free|red"`
+	Data     struct {
+		UserID string `json:"user_id" validate:"required,uuid"`
+	} `json:"data"`
+	Owner uuid.UUID `json:"owner" validate:"required"`
+}
+
+func valid() signup {
+	var s signup
+	s.Email = "walt@example.com"
+	s.Password = "04234"
+	s.Data.UserID = uuid.NewString()
+	s.Owner = uuid.New()
+	return s
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(s *signup)
+		want   []FieldError
+	}{
+		{
+			name:   "valid",
+			modify: func(s *signup) {},
+		},
+		{
+			name:   "missing email",
+			modify: func(s *signup) { s.Email = "" },
+			want:   []FieldError{{Field: "email", Rule: "required", Message: "is required"}},
+		},
+		{
+			name:   "bad email",
+			modify: func(s *signup) { s.Email = "Walt <walt@example.com>" },
+			want:   []FieldError{{Field: "email", Rule: "email", Message: "must be a valid email address"}},
+		},
+		{
+			name:   "too short",
+			modify: func(s *signup) { s.Password = "abc" },
+			want:   []FieldError{{Field: "password", Rule: "min", Message: "must be at least 4 characters"}},
+		},
+		{
+			name:   "length counts characters not bytes",
+			modify: func(s *signup) { s.Password = strings.Repeat("é", 72) },
+		},
+		{
+			name:   "too long",
+			modify: func(s *signup) { s.Password = strings.Repeat("a", 73) },
+			want:   []FieldError{{Field: "password", Rule: "max", Message: "must be at most 72 characters"}},
+		},
+		{
+			name:   "oneof",
+			modify: func(s *signup) { s.Plan = "gold" },
+			want:   []FieldError{{Field: "plan", Rule: "oneof", Message: "must be one of free, red"}},
+		},
+		{
+			name:   "nested uuid",
+			modify: func(s *signup) { s.Data.UserID = "nope" },
+			want:   []FieldError{{Field: "data.user_id", Rule: "uuid", Message: "must be a UUID"}},
+		},
+		{
+			name: "every failing field is reported",
+			modify: func(s *signup) {
+				s.Email = ""
+				s.Password = ""
+				s.Owner = uuid.Nil
+			},
+			want: []FieldError{
+				{Field: "email", Rule: "required", Message: "is required"},
+				{Field: "password", Rule: "required", Message: "is required"},
+				{Field: "owner", Rule: "required", Message: "is required"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid()
+			tt.modify(&s)
+			err := Struct(&s)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Struct() error = %v, want nil", err)
+				}
+				return
+			}
+			var got Errors
+			if !errors.As(err, &got) {
+				t.Fatalf("Struct() error = %v, want Errors", err)
+			}
+			if !reflect.DeepEqual([]FieldError(got), tt.want) {
+				t.Errorf("Struct() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnknownRulePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Struct() did not panic on an unknown rule")
+		}
+	}()
+	Struct(struct {
+		Name string `validate:"shiny"`
+	}{Name: "x"})
+}
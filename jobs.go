@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/config"
+	"github.com/05blue04/chirpy/internal/jobs"
+)
+
+// newJobQueue builds the queue described by conf. Its workers still have to
+// be started with its Run method.
+func newJobQueue(conf config.Jobs, db *sql.DB) (*jobs.Queue, error) {
+	var store jobs.Store = jobs.NewMemory()
+	if conf.Store == "postgres" {
+		store = jobs.NewPostgres(db)
+	}
+	return jobs.New(store, jobs.Options{
+		Concurrency:  conf.Concurrency,
+		PollInterval: time.Duration(conf.PollInterval),
+		MaxAttempts:  conf.MaxAttempts,
+		Timeout:      time.Duration(conf.Timeout),
+		Retention:    time.Duration(conf.Retention),
+	})
+}
+
+// registerJobs gives cfg.jobs the handlers and schedules of the jobs the
+// server runs. It must be called before the queue is started.
+func (cfg *apiConfig) registerJobs() error {
+	cfg.jobs.Register(jobSendVerification, cfg.sendVerification)
+	cfg.jobs.Register(jobSweepTokens, cfg.sweepTokens)
+	return cfg.jobs.Schedule(jobSweepTokens, cfg.conf.TokenSweep.Schedule, jobSweepTokens, nil)
+}
+
+const (
+	defaultJobsLimit = 50
+	maxJobsLimit     = 500
+)
+
+type jobsResponse struct {
+	Counts map[string]int `json:"counts"`
+	Jobs   []jobs.Job     `json:"jobs"`
+}
+
+// jobsHandler lists the most recent jobs with how many are in each state.
+// Job errors can quote the database or mail server, so like reset it is only
+// served in development.
+func (cfg *apiConfig) jobsHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.platform != "dev" {
+		respondWithError(w, r, newAppError(codeForbidden, "Jobs are only listed when PLATFORM is dev.", nil))
+		return
+	}
+	state := r.URL.Query().Get("state")
+	if state != "" && !slices.Contains(jobs.States, state) {
+		respondWithError(w, r, newAppError(codeInvalidParameter, fmt.Sprintf("state must be one of %v.", jobs.States), nil))
+		return
+	}
+	limit := defaultJobsLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxJobsLimit {
+			respondWithError(w, r, newAppError(codeInvalidParameter, fmt.Sprintf("limit must be between 1 and %d.", maxJobsLimit), err))
+			return
+		}
+		limit = n
+	}
+
+	counts, err := cfg.jobs.Counts(r.Context())
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	list, err := cfg.jobs.List(r.Context(), state, limit)
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []jobs.Job{}
+	}
+	respondWithJSON(w, http.StatusOK, jobsResponse{Counts: counts, Jobs: list})
+}
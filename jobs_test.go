@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/database"
+	"github.com/05blue04/chirpy/internal/jobs"
+	"github.com/05blue04/chirpy/internal/store"
+	"github.com/google/uuid"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestJobsHandler(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	c := newTestClient(t, cfg)
+	ctx := context.Background()
+	for _, kind := range []string{"first", "second", "third"} {
+		if err := cfg.jobs.Enqueue(ctx, kind, map[string]string{"secret": "hunter2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var all jobsResponse
+	c.expect(c.do("GET", "/admin/jobs?limit=2", "", nil), http.StatusOK, &all)
+	if all.Counts[jobs.Pending] != 3 || all.Counts[jobs.Dead] != 0 {
+		t.Errorf("counts = %v", all.Counts)
+	}
+	if _, ok := all.Counts[jobs.Dead]; !ok {
+		t.Error("counts leave out states with no jobs")
+	}
+	if len(all.Jobs) != 2 || all.Jobs[0].Kind != "third" {
+		t.Errorf("jobs = %+v, want the newest two", all.Jobs)
+	}
+	// Payloads can hold personal data or secrets, so they are not listed.
+	if resp := c.do("GET", "/admin/jobs", "", nil); strings.Contains(string(resp.body), "hunter2") {
+		t.Errorf("job listing includes payloads: %s", resp.body)
+	}
+
+	var dead jobsResponse
+	c.expect(c.do("GET", "/admin/jobs?state=dead", "", nil), http.StatusOK, &dead)
+	if dead.Jobs == nil || len(dead.Jobs) != 0 {
+		t.Errorf("dead jobs = %+v, want an empty list", dead.Jobs)
+	}
+
+	c.expectProblem(c.do("GET", "/admin/jobs?state=stuck", "", nil), codeInvalidParameter)
+	c.expectProblem(c.do("GET", "/admin/jobs?limit=0", "", nil), codeInvalidParameter)
+
+	cfg.platform = "prod"
+	c.expectProblem(c.do("GET", "/admin/jobs", "", nil), codeForbidden)
+}
+
+func TestSweepTokens(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	cfg.conf.TokenSweep.BatchSize = 2
+	ctx := context.Background()
+	now := time.Now()
+	u, err := cfg.db.CreateUser(ctx, database.CreateUserParams{
+		ID:             uuid.New(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Email:          "a@example.com",
+		HashedPassword: "hash",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(token string, expiresAt time.Time) {
+		t.Helper()
+		err := cfg.db.CreateToken(ctx, database.CreateTokenParams{
+			Token:     token,
+			CreatedAt: now,
+			UpdatedAt: now,
+			UserID:    u.ID,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 5 {
+		create(fmt.Sprintf("expired-%d", i), now.Add(-time.Hour))
+	}
+	create("live", now.Add(time.Hour))
+
+	if err := cfg.sweepTokens(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		if _, err := cfg.db.GetTokenByID(ctx, fmt.Sprintf("expired-%d", i)); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expired token %d still there: %v", i, err)
+		}
+	}
+	if _, err := cfg.db.GetTokenByID(ctx, "live"); err != nil {
+		t.Errorf("live token: %v", err)
+	}
+	var m dto.Metric
+	if err := cfg.metrics.tokensDeleted.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 5 {
+		t.Errorf("tokens deleted = %v, want 5", got)
+	}
+}
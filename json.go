@@ -2,30 +2,51 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
-func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
-	if err != nil {
-		log.Println(err)
+// respondWithError reports err as an RFC 7807 problem. Only the detail of
+// the appError it maps to reaches the client; the full error, including any
+// internal cause, goes to the access log and, for 5xx, the error log.
+func respondWithError(w http.ResponseWriter, r *http.Request, err error) {
+	appErr := toAppError(err)
+	pt := problemTypes[appErr.code]
+	id := requestIDFrom(r.Context())
+
+	setRequestError(r, appErr)
+	if pt.status > 499 {
+		slog.ErrorContext(r.Context(), "Responding with 5XX error",
+			"request_id", id,
+			"code", appErr.code,
+			"error", appErr.Error(),
+		)
 	}
-	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+
+	p := problem{
+		Type:      problemTypeURI(appErr.code),
+		Title:     pt.title,
+		Status:    pt.status,
+		Detail:    appErr.detail,
+		Code:      appErr.code,
+		RequestID: id,
+		Errors:    appErr.fields,
 	}
-	type errorResponse struct {
-		Error string `json:"error"`
+	if id != "" {
+		p.Instance = "urn:chirpy:request:" + id
 	}
-	respondWithJSON(w, code, errorResponse{
-		Error: msg,
-	})
+	writeJSON(w, pt.status, "application/problem+json", p)
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, code, "application/json", payload)
+}
+
+func writeJSON(w http.ResponseWriter, code int, contentType string, payload interface{}) {
+	w.Header().Set("Content-Type", contentType)
 	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		slog.Error("Error marshalling JSON", "error", err)
 		w.WriteHeader(500)
 		return
 	}
@@ -1,72 +1,202 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"sync/atomic"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
-	"github.com/05blue04/chirpy/internal/database"
+	"github.com/05blue04/chirpy/internal/config"
+	"github.com/05blue04/chirpy/internal/jobs"
+	"github.com/05blue04/chirpy/internal/mail"
+	"github.com/05blue04/chirpy/internal/migrate"
+	"github.com/05blue04/chirpy/internal/pubsub"
+	"github.com/05blue04/chirpy/internal/ratelimit"
+	"github.com/05blue04/chirpy/internal/store"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	db             *database.Queries
-	platform       string
-	secret         string
-	apiKey         string
+	conf     config.Config
+	db       store.Store
+	conn     *sql.DB
+	schema   int64
+	metrics  *appMetrics
+	workers  *workerGroup
+	limiter  *ratelimit.Limiter
+	hub      *pubsub.Hub
+	bus      pubsub.Bus
+	jobs     *jobs.Queue
+	mailer   mail.Mailer
+	platform string
+	secret   string
+	apiKey   string
+	// openAPI is the encoded OpenAPI document, built from the route table
+	// when the router is.
+	openAPI []byte
 }
 
 func main() {
+	// .env is a development convenience; deployments set the environment
+	// directly.
 	err := godotenv.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("error loading .env: %v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	conf, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var level slog.Level
+	level.UnmarshalText([]byte(conf.LogLevel))
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+
+	if err := run(conf); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(conf config.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		return fmt.Errorf("couldnt set up tracing: %w", err)
+	}
+
+	conn, err := store.Open(conf.DBURL)
+	if err != nil {
+		return fmt.Errorf("couldnt connect to database: %w", err)
+	}
+	if conf.AutoMigrate {
+		m, err := migrate.New(conn.DB, conn.Backend)
+		if err != nil {
+			return err
+		}
+		results, err := m.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("auto-migrate failed: %w", err)
+		}
+		slog.Info("applied migrations", "count", len(results))
+	}
+
+	schemaVersion, err := migrate.Latest(conn.Backend)
+	if err != nil {
+		return err
+	}
+
+	limiter, err := newRateLimiter(conf.RateLimit, conn.DB)
 	if err != nil {
-		log.Fatal("error loading environment variables")
+		return err
 	}
 
-	const port = "8080"
-	mux := http.NewServeMux()
+	queue, err := newJobQueue(conf.Jobs, conn.DB)
+	if err != nil {
+		return err
+	}
 
-	db, err := sql.Open("postgres", os.Getenv("DB_URL"))
+	mailer, err := newMailer(conf.Mail)
 	if err != nil {
-		log.Fatalf("Couldnt connect to database: %v", err)
+		return err
 	}
+
+	hub := pubsub.NewHub(conf.Stream.ReplaySize)
+	bus := newEventBus(conf.Stream, conn.DB, conf.DBURL, hub)
+
 	cfg := apiConfig{
-		fileserverHits: atomic.Int32{},
-		db:             database.New(db),
-		platform:       os.Getenv("PLATFORM"),
-		secret:         os.Getenv("JWT_SECRET"),
-		apiKey:         os.Getenv("POLKA_KEY"),
-	}
-
-	handler := http.StripPrefix("/app/", http.FileServer(http.Dir(".")))
-
-	mux.Handle("/app/", cfg.middlewareMetricsInc(handler))
-	mux.Handle("/app/assets/", cfg.middlewareMetricsInc(http.StripPrefix("/app/assets/", http.FileServer(http.Dir("./assets")))))
-	mux.HandleFunc("GET /api/healthz", handlerReadiness)
-	mux.HandleFunc("GET /admin/metrics", cfg.metricHandler)
-	mux.HandleFunc("POST /admin/reset", cfg.resetHandler)
-	//users
-	mux.HandleFunc("POST /api/users", cfg.usersHandler)
-	mux.HandleFunc("POST /api/login", cfg.loginHandler)
-	mux.HandleFunc("POST /api/refresh", cfg.refreshHandler)
-	mux.HandleFunc("POST /api/revoke", cfg.revokeHandler)
-	mux.HandleFunc("PUT /api/users", cfg.updateUserHandler)
-	mux.HandleFunc("POST /api/polka/webhooks", cfg.polkaHandler)
-	//chirps
-	mux.HandleFunc("POST /api/chirps", cfg.createChirpHandler)
-	mux.HandleFunc("GET /api/chirps", cfg.getChirpsHandler)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.getChirpByIDHandler)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.deleteChirpHandler)
+		conf:     conf,
+		db:       conn.Store,
+		conn:     conn.DB,
+		schema:   schemaVersion,
+		metrics:  newAppMetrics(conn.DB),
+		workers:  newWorkerGroup(),
+		limiter:  limiter,
+		hub:      hub,
+		bus:      bus,
+		jobs:     queue,
+		mailer:   mailer,
+		platform: conf.Platform,
+		secret:   conf.JWTSecret,
+		apiKey:   conf.PolkaKey,
+	}
+
+	if limiter != nil {
+		cfg.workers.Go("rate-limit-pruner", limiter.Run)
+	}
+	if pg, ok := bus.(*pubsub.Postgres); ok {
+		cfg.workers.Go("event-bus", pg.Run)
+	}
+	if err := cfg.registerJobs(); err != nil {
+		return err
+	}
+	cfg.workers.Go("job-queue", queue.Run)
+
 	server := &http.Server{
-		Handler: mux,
-		Addr:    ":" + port,
+		Handler:           cfg.routes(),
+		Addr:              ":" + strconv.Itoa(conf.Port),
+		ReadHeaderTimeout: time.Duration(conf.Server.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(conf.Server.ReadTimeout),
+		WriteTimeout:      time.Duration(conf.Server.WriteTimeout),
+		IdleTimeout:       time.Duration(conf.Server.IdleTimeout),
+		MaxHeaderBytes:    conf.Server.MaxHeaderBytes,
 	}
+	// Shutdown waits for handlers to return, so end the streams that would
+	// otherwise run until the timeout.
+	server.RegisterOnShutdown(cfg.hub.Close)
 
-	err = server.ListenAndServe()
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server listening", "addr", server.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	case <-ctx.Done():
+		slog.Info("shutting down")
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(conf.Server.ShutdownTimeout))
+	defer cancel()
+
+	// Stop accepting requests and let in-flight ones finish before tearing
+	// down what they depend on.
+	err = errors.Join(
+		server.Shutdown(shutdownCtx),
+		cfg.workers.Shutdown(shutdownCtx),
+		shutdownTracing(shutdownCtx),
+		conn.DB.Close(),
+	)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("error during shutdown: %w", err)
 	}
+	slog.Info("shutdown complete")
+	return nil
 }
@@ -1,20 +1,210 @@
 package main
 
 import (
-	"fmt"
+	"database/sql"
+	"html/template"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
-func (cfg *apiConfig) metricHandler(w http.ResponseWriter, r *http.Request) {
-	s := fmt.Sprintf(`
+type appMetrics struct {
+	registry       *prometheus.Registry
+	requests       *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	inFlight       prometheus.Gauge
+	fileserverHits prometheus.Counter
+	logins         *prometheus.CounterVec
+	chirpsCreated  prometheus.Counter
+	rateLimited    *prometheus.CounterVec
+	streamClients  *prometheus.GaugeVec
+	tokensDeleted  prometheus.Counter
+}
+
+func newAppMetrics(db *sql.DB) *appMetrics {
+	m := &appMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_http_requests_total",
+			Help: "HTTP requests handled, by method, route pattern and status code.",
+		}, []string{"method", "route", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chirpy_http_request_duration_seconds",
+			Help:    "HTTP request latency, by method and route pattern.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "chirpy_http_requests_in_flight",
+			Help: "HTTP requests currently being served.",
+		}),
+		fileserverHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chirpy_fileserver_hits_total",
+			Help: "Requests served by the /app/ file server.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_logins_total",
+			Help: "Login attempts, by result.",
+		}, []string{"result"}),
+		chirpsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chirpy_chirps_created_total",
+			Help: "Chirps successfully created.",
+		}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_rate_limited_total",
+			Help: "Requests refused by a rate limit, by policy.",
+		}, []string{"policy"}),
+		streamClients: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "chirpy_stream_clients",
+			Help: "Clients connected for live events, by transport (sse or websocket).",
+		}, []string{"transport"}),
+		tokensDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chirpy_refresh_tokens_deleted_total",
+			Help: "Expired or long-revoked refresh tokens deleted by the sweeper.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.inFlight,
+		m.fileserverHits,
+		m.logins,
+		m.chirpsCreated,
+		m.rateLimited,
+		m.streamClients,
+		m.tokensDeleted,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, "chirpy"))
+	}
+
+	// Pre-create the label values so they are exported as zero before the
+	// first login or connection.
+	m.logins.WithLabelValues("success")
+	m.logins.WithLabelValues("failure")
+	m.streamClients.WithLabelValues("sse")
+	m.streamClients.WithLabelValues("websocket")
+
+	return m
+}
+
+func (m *appMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+func (m *appMetrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		route := "unmatched"
+		if info := requestInfoFrom(r.Context()); info != nil && info.pattern != "" {
+			route = info.pattern
+		}
+		m.requests.WithLabelValues(r.Method, route, strconv.Itoa(rec.status)).Inc()
+		m.duration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+var metricsPage = template.Must(template.New("metrics").Parse(`
 <html>
   <body>
     <h1>Welcome, Chirpy Admin</h1>
-    <p>Chirpy has been visited %d times!</p>
+    <p>Chirpy has been visited {{.Hits}} times!</p>
+    <table>
+      <tr><th>Metric</th><th>Labels</th><th>Value</th></tr>
+      {{- range .Rows}}
+      <tr><td title="{{.Help}}">{{.Name}}</td><td>{{.Labels}}</td><td>{{.Value}}</td></tr>
+      {{- end}}
+    </table>
   </body>
-</html>`, cfg.fileserverHits.Load())
+</html>`))
+
+type metricsRow struct {
+	Name   string
+	Help   string
+	Labels string
+	Value  string
+}
+
+func (cfg *apiConfig) metricHandler(w http.ResponseWriter, r *http.Request) {
+	families, err := cfg.metrics.registry.Gather()
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+
+	var hits float64
+	var rows []metricsRow
+	for _, mf := range families {
+		if mf.GetName() == "chirpy_fileserver_hits_total" && len(mf.GetMetric()) > 0 {
+			hits = mf.GetMetric()[0].GetCounter().GetValue()
+		}
+		// Runtime and process metrics are left to /metrics.
+		if !strings.HasPrefix(mf.GetName(), "chirpy_") {
+			continue
+		}
+		for _, metric := range mf.GetMetric() {
+			rows = append(rows, metricsRow{
+				Name:   mf.GetName(),
+				Help:   mf.GetHelp(),
+				Labels: formatLabels(metric.GetLabel()),
+				Value:  formatMetricValue(mf.GetType(), metric),
+			})
+		}
+	}
 
 	w.Header().Add("Content-Type", "text/html")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(s))
+	metricsPage.Execute(w, struct {
+		Hits int64
+		Rows []metricsRow
+	}{
+		Hits: int64(hits),
+		Rows: rows,
+	})
+}
+
+func formatLabels(pairs []*dto.LabelPair) string {
+	labels := make([]string, len(pairs))
+	for i, p := range pairs {
+		labels[i] = p.GetName() + "=" + strconv.Quote(p.GetValue())
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ", ")
+}
+
+func formatMetricValue(t dto.MetricType, m *dto.Metric) string {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return strconv.FormatFloat(m.GetCounter().GetValue(), 'g', -1, 64)
+	case dto.MetricType_GAUGE:
+		return strconv.FormatFloat(m.GetGauge().GetValue(), 'g', -1, 64)
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		return "count=" + strconv.FormatUint(h.GetSampleCount(), 10) +
+			" sum=" + strconv.FormatFloat(h.GetSampleSum(), 'g', -1, 64)
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		return "count=" + strconv.FormatUint(s.GetSampleCount(), 10) +
+			" sum=" + strconv.FormatFloat(s.GetSampleSum(), 'g', -1, 64)
+	default:
+		return strconv.FormatFloat(m.GetUntyped().GetValue(), 'g', -1, 64)
+	}
 }
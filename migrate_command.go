@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/05blue04/chirpy/internal/config"
+	"github.com/05blue04/chirpy/internal/migrate"
+	"github.com/05blue04/chirpy/internal/store"
+)
+
+const migrateUsage = "usage: chirpy migrate up|down|status|redo [flags]"
+
+// runMigrate implements `chirpy migrate <command>`. Only the database
+// settings are required, so it can run before the rest of the deploy is
+// configured.
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	command := args[0]
+
+	conf, err := config.Parse(args[1:], os.Getenv)
+	if err != nil {
+		return err
+	}
+	if err := conf.ValidateDatabase(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	conn, err := store.Open(conf.DBURL)
+	if err != nil {
+		return fmt.Errorf("couldnt connect to database: %w", err)
+	}
+	defer conn.DB.Close()
+
+	m, err := migrate.New(conn.DB, conn.Backend)
+	if err != nil {
+		return err
+	}
+	return m.Run(ctx, command, os.Stdout)
+}
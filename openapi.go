@@ -0,0 +1,473 @@
+package main
+
+import (
+	"embed"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// operation documents a route for the OpenAPI spec.
+type operation struct {
+	summary     string
+	description string
+	tag         string
+	deprecated  bool
+	// security names the scheme the route authenticates with, if any.
+	security string
+	params   []parameter
+	// request is a value of the JSON request body's type, or nil.
+	request any
+	// status is the success status; response is a value of the JSON body
+	// sent with it, or nil. contentType describes a body that is not JSON.
+	status      int
+	response    any
+	contentType string
+	// conditional routes send ETags and answer conditional GETs with 304.
+	conditional bool
+	// errors lists the error codes particular to the route. Those implied by
+	// the request body and security scheme are added automatically.
+	errors []errorCode
+}
+
+type parameter struct {
+	name        string
+	in          string // "path", "query" or "header"
+	description string
+	format      string
+	enum        []string
+}
+
+const (
+	securityBearer       = "bearerAuth"
+	securityRefreshToken = "refreshToken"
+	securityPolkaKey     = "polkaApiKey"
+)
+
+var securitySchemes = map[string]openAPISecurityScheme{
+	securityBearer: {
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+		Description:  "An access token from POST /api/v1/login or POST /api/v1/refresh.",
+	},
+	securityRefreshToken: {
+		Type:        "http",
+		Scheme:      "bearer",
+		Description: "A refresh token from POST /api/v1/login.",
+	},
+	securityPolkaKey: {
+		Type:        "apiKey",
+		In:          "header",
+		Name:        "Authorization",
+		Description: "The Polka API key, sent as 'ApiKey <key>'.",
+	},
+}
+
+// The OpenAPI 3.1 objects below cover only what the route table uses.
+
+type openAPIDoc struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Schema      *jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *jsonSchema `json:"schema,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*jsonSchema           `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// jsonSchema is the subset of JSON Schema 2020-12 the generator emits.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+}
+
+// newOpenAPI describes routes as an OpenAPI 3.1 document.
+func newOpenAPI(routes []route) openAPIDoc {
+	schemas := schemaSet{}
+	doc := openAPIDoc{
+		OpenAPI: "3.1.0",
+		Info: openAPIInfo{
+			Title:       "Chirpy API",
+			Version:     "1.0.0",
+			Description: "Errors are RFC 7807 problem details; the code field is stable and safe to switch on.",
+		},
+		Paths: map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas:         schemas,
+			SecuritySchemes: securitySchemes,
+		},
+	}
+	problemSchema := schemas.of(reflect.TypeFor[problem]())
+
+	for _, r := range routes {
+		if doc.Paths[r.path] == nil {
+			doc.Paths[r.path] = map[string]*openAPIOperation{}
+		}
+		op := r.doc
+		if r.limit != "" {
+			op.errors = append(slices.Clone(op.errors), codeRateLimited)
+		}
+		doc.Paths[r.path][strings.ToLower(r.method)] = op.describe(schemas, problemSchema)
+	}
+	return doc
+}
+
+func (op operation) describe(schemas schemaSet, problemSchema *jsonSchema) *openAPIOperation {
+	out := &openAPIOperation{
+		Summary:     op.summary,
+		Description: op.description,
+		Deprecated:  op.deprecated,
+		Responses:   map[string]openAPIResponse{},
+	}
+	if op.tag != "" {
+		out.Tags = []string{op.tag}
+	}
+	codes := slices.Clone(op.errors)
+
+	if op.security != "" {
+		out.Security = []map[string][]string{{op.security: {}}}
+		codes = append(codes, codeUnauthenticated)
+		if op.security != securityPolkaKey {
+			codes = append(codes, codeInvalidToken)
+		}
+	}
+
+	for _, p := range op.params {
+		out.Parameters = append(out.Parameters, openAPIParameter{
+			Name:        p.name,
+			In:          p.in,
+			Description: p.description,
+			Required:    p.in == "path",
+			Schema:      &jsonSchema{Type: "string", Format: p.format, Enum: p.enum},
+		})
+	}
+
+	if op.conditional {
+		out.Parameters = append(out.Parameters,
+			openAPIParameter{
+				Name:        "If-None-Match",
+				In:          "header",
+				Description: "ETags of copies the client holds; a match gets a 304.",
+				Schema:      &jsonSchema{Type: "string"},
+			},
+			openAPIParameter{
+				Name:        "If-Modified-Since",
+				In:          "header",
+				Description: "Ignored when If-None-Match is sent.",
+				Schema:      &jsonSchema{Type: "string"},
+			})
+		out.Responses[strconv.Itoa(http.StatusNotModified)] = openAPIResponse{
+			Description: "The client's copy, named by If-None-Match or If-Modified-Since, is current.",
+		}
+	}
+
+	if op.request != nil {
+		out.RequestBody = &openAPIRequestBody{
+			Required: true,
+			Content: map[string]openAPIMediaType{
+				"application/json": {Schema: schemas.of(reflect.TypeOf(op.request))},
+			},
+		}
+		codes = append(codes, codeMalformedBody, codeUnsupportedMedia, codeBodyTooLarge, codeValidationFailed)
+	}
+
+	success := openAPIResponse{Description: http.StatusText(op.status)}
+	switch {
+	case op.response != nil:
+		success.Content = map[string]openAPIMediaType{
+			"application/json": {Schema: schemas.of(reflect.TypeOf(op.response))},
+		}
+	case op.contentType != "":
+		success.Content = map[string]openAPIMediaType{op.contentType: {}}
+	}
+	out.Responses[strconv.Itoa(op.status)] = success
+
+	codes = append(codes, codeInternal)
+	for status, descr := range describeErrors(codes) {
+		out.Responses[strconv.Itoa(status)] = openAPIResponse{
+			Description: descr,
+			Content: map[string]openAPIMediaType{
+				"application/problem+json": {Schema: problemSchema},
+			},
+		}
+	}
+	return out
+}
+
+// describeErrors groups codes by HTTP status and lists each group's codes
+// and titles, since several codes can share a status.
+func describeErrors(codes []errorCode) map[int]string {
+	slices.Sort(codes)
+	codes = slices.Compact(codes)
+	lines := map[int][]string{}
+	for _, code := range codes {
+		pt, ok := problemTypes[code]
+		if !ok {
+			panic(fmt.Sprintf("openapi: error code %q has no problem type", code))
+		}
+		lines[pt.status] = append(lines[pt.status], fmt.Sprintf("`%s`: %s", code, pt.title))
+	}
+	out := make(map[int]string, len(lines))
+	for status, l := range lines {
+		out[status] = strings.Join(l, "\n\n")
+	}
+	return out
+}
+
+// schemaSet holds the component schemas of named struct types, keyed by
+// their exported names.
+type schemaSet map[string]*jsonSchema
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	uuidType          = reflect.TypeFor[uuid.UUID]()
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// of returns the schema for values of type t as encoding/json writes them.
+// Named structs are added to the set and referenced.
+func (s schemaSet) of(t reflect.Type) *jsonSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case t == uuidType:
+		return &jsonSchema{Type: "string", Format: "uuid"}
+	case t.Implements(jsonMarshalerType), t.Implements(textMarshalerType),
+		reflect.PointerTo(t).Implements(jsonMarshalerType), reflect.PointerTo(t).Implements(textMarshalerType):
+		// Every marshaler in the API writes a string, such as a Duration.
+		return &jsonSchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: s.of(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: s.of(t.Elem())}
+	case reflect.Interface:
+		return &jsonSchema{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := s[name]; !ok {
+			s[name] = nil // placeholder, in case the type refers to itself
+			s[name] = s.object(t)
+		}
+		return &jsonSchema{Ref: "#/components/schemas/" + name}
+	default:
+		panic(fmt.Sprintf("openapi: no schema for %s", t))
+	}
+}
+
+// object describes a struct. A field is required if its validate tag says
+// so; for structs without validate tags, which are responses, every field
+// that is not omitempty is.
+func (s schemaSet) object(t reflect.Type) *jsonSchema {
+	out := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+	validated := false
+	for sf := range fields(t) {
+		if sf.Tag.Get("validate") != "" {
+			validated = true
+		}
+	}
+
+	for sf := range fields(t) {
+		name, opts, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		prop := s.of(sf.Type)
+
+		required := !validated && !slices.Contains(strings.Split(opts, ","), "omitempty")
+		for _, rule := range strings.Split(sf.Tag.Get("validate"), ",") {
+			rule, arg, _ := strings.Cut(rule, "=")
+			switch rule {
+			case "required":
+				required = true
+			case "email", "uuid":
+				prop.Format = rule
+			case "min":
+				n, _ := strconv.Atoi(arg)
+				prop.MinLength = &n
+			case "max":
+				n, _ := strconv.Atoi(arg)
+				prop.MaxLength = &n
+			case "oneof":
+				prop.Enum = strings.Split(arg, "|")
+			}
+		}
+		if required {
+			out.Required = append(out.Required, name)
+		}
+		out.Properties[name] = prop
+	}
+	return out
+}
+
+func fields(t reflect.Type) func(yield func(reflect.StructField) bool) {
+	return func(yield func(reflect.StructField) bool) {
+		for i := range t.NumField() {
+			if sf := t.Field(i); sf.IsExported() && !yield(sf) {
+				return
+			}
+		}
+	}
+}
+
+// schemaName exports t's name, so problem is listed as Problem.
+func schemaName(t reflect.Type) string {
+	r := []rune(t.Name())
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+func mustMarshalOpenAPI(routes []route) []byte {
+	dat, err := json.Marshal(newOpenAPI(routes))
+	if err != nil {
+		panic(fmt.Sprintf("openapi: %v", err))
+	}
+	return dat
+}
+
+func (cfg *apiConfig) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(cfg.openAPI)
+}
+
+// viewerFS holds the script and stylesheet that render the API reference.
+// Serving them from the binary keeps the docs page working offline and free
+// of third-party scripts.
+//
+//go:embed docs/viewer
+var viewerFS embed.FS
+
+// apiDocsPage renders /api/openapi.json with the embedded viewer.
+const apiDocsPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>Chirpy API</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <link rel="stylesheet" href="/api/docs/viewer.css">
+  </head>
+  <body>
+    <main id="reference">
+      <p>Loading the API reference. The OpenAPI document is at <a href="/api/openapi.json">/api/openapi.json</a>.</p>
+    </main>
+    <script src="/api/docs/viewer.js"></script>
+  </body>
+</html>
+`
+
+// apiDocsPolicy replaces the default Content-Security-Policy on the docs
+// page: the page, its viewer and the document it fetches all come from this
+// origin.
+const apiDocsPolicy = "default-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'"
+
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Security-Policy", apiDocsPolicy)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(apiDocsPage))
+}
+
+// viewerHandler serves a file of the embedded API reference viewer.
+func viewerHandler(name, contentType string) http.HandlerFunc {
+	dat, err := fs.ReadFile(viewerFS, "docs/viewer/"+name)
+	if err != nil {
+		panic(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", assetCacheControl)
+		w.WriteHeader(http.StatusOK)
+		w.Write(dat)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/05blue04/chirpy/internal/store"
+)
+
+// TestOpenAPICoversRoutes fails when a route is served but missing from the
+// spec, which happens if it is registered outside the route table.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	rt := cfg.router()
+	doc := newOpenAPI(cfg.apiRoutes())
+
+	for _, pattern := range rt.patterns {
+		if slices.Contains(undocumentedPatterns, pattern) {
+			continue
+		}
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			t.Errorf("pattern %q has no method; give it one and add it to the route table", pattern)
+			continue
+		}
+		if doc.Paths[path][strings.ToLower(method)] == nil {
+			t.Errorf("%s is served but not in the OpenAPI spec; add it to apiRoutes", pattern)
+		}
+	}
+}
+
+func TestOpenAPIRefsResolve(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	doc := newOpenAPI(cfg.apiRoutes())
+	for _, name := range []string{"Chirp", "User", "LoginResponse", "Problem"} {
+		if doc.Components.Schemas[name] == nil {
+			t.Errorf("components.schemas has no %s", name)
+		}
+	}
+
+	dat, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tree any
+	if err := json.Unmarshal(dat, &tree); err != nil {
+		t.Fatal(err)
+	}
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				name, found := strings.CutPrefix(ref, "#/components/schemas/")
+				if !found || doc.Components.Schemas[name] == nil {
+					t.Errorf("$ref %q does not resolve", ref)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(tree)
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	schemas := newOpenAPI(cfg.apiRoutes()).Components.Schemas
+
+	req := schemas["CreateUserRequest"]
+	if req == nil {
+		t.Fatal("components.schemas has no CreateUserRequest")
+	}
+	if !slices.Equal(req.Required, []string{"password", "email"}) {
+		t.Errorf("CreateUserRequest required = %v, want [password email]", req.Required)
+	}
+	if email := req.Properties["email"]; email.Format != "email" || email.MaxLength == nil || *email.MaxLength != 254 {
+		t.Errorf("CreateUserRequest email = %+v, want format email, maxLength 254", email)
+	}
+
+	chirp := schemas["Chirp"]
+	if got := chirp.Properties["created_at"]; got.Type != "string" || got.Format != "date-time" {
+		t.Errorf("Chirp created_at = %+v, want a date-time string", got)
+	}
+	if got := chirp.Properties["user_id"]; got.Format != "uuid" {
+		t.Errorf("Chirp user_id = %+v, want a uuid string", got)
+	}
+	if len(chirp.Required) != len(chirp.Properties) {
+		t.Errorf("Chirp required = %v, want every property", chirp.Required)
+	}
+
+	login := schemas["LoginRequest"]
+	if slices.Contains(login.Required, "expires_in_seconds") {
+		t.Error("LoginRequest requires expires_in_seconds, which is optional")
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	c := newTestClient(t, newTestConfig(t, store.NewMemory(), nil, 0))
+
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	c.expect(c.do("GET", "/api/openapi.json", "", nil), http.StatusOK, &doc)
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("openapi = %q, want 3.1.0", doc.OpenAPI)
+	}
+	if doc.Paths["/api/v1/chirps/{chirpID}"]["delete"] == nil {
+		t.Error("spec is missing DELETE /api/v1/chirps/{chirpID}")
+	}
+
+	resp := c.do("GET", "/api/docs", "", nil)
+	if resp.status != http.StatusOK || !strings.Contains(string(resp.body), "/api/openapi.json") {
+		t.Errorf("GET /api/docs = %d %q", resp.status, resp.body)
+	}
+	// The viewer comes from the binary, never from a CDN.
+	if strings.Contains(string(resp.body), "https://") {
+		t.Errorf("docs page loads from another origin: %s", resp.body)
+	}
+	for _, asset := range []struct{ path, contentType string }{
+		{"/api/docs/viewer.js", "text/javascript"},
+		{"/api/docs/viewer.css", "text/css"},
+	} {
+		if !strings.Contains(string(resp.body), asset.path) {
+			t.Errorf("docs page does not load %s: %s", asset.path, resp.body)
+		}
+		got := c.do("GET", asset.path, "", nil)
+		if got.status != http.StatusOK || !strings.HasPrefix(got.header.Get("Content-Type"), asset.contentType) || len(got.body) == 0 {
+			t.Errorf("GET %s = %d %q, %d bytes", asset.path, got.status, got.header.Get("Content-Type"), len(got.body))
+		}
+	}
+}
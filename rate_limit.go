@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/auth"
+	"github.com/05blue04/chirpy/internal/config"
+	"github.com/05blue04/chirpy/internal/ratelimit"
+)
+
+// newRateLimiter builds the limiter described by conf, or returns nil if
+// rate limiting is off.
+func newRateLimiter(conf config.RateLimit, db *sql.DB) (*ratelimit.Limiter, error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+	var store ratelimit.Store = ratelimit.NewMemory()
+	if conf.Store == "postgres" {
+		store = ratelimit.NewPostgres(db)
+	}
+	policies := make(map[string]ratelimit.Policy, len(conf.Policies))
+	for name, p := range conf.Policies {
+		policies[name] = ratelimit.Policy{Limit: p.Limit, Period: time.Duration(p.Period), Burst: p.Burst}
+	}
+	return ratelimit.New(store, policies)
+}
+
+// middlewareRateLimit applies the named policy to next, keyed by the
+// caller's user ID if they send a valid access token and by their address
+// otherwise. Every response reports the caller's standing in RateLimit-*
+// headers; refused requests get a 429. If the limiter's store fails the
+// request is let through, since an outage there should not take the API
+// down with it.
+func (cfg *apiConfig) middlewareRateLimit(policy string, next http.HandlerFunc) http.Handler {
+	if cfg.limiter == nil || policy == "" {
+		return next
+	}
+	p, ok := cfg.limiter.Policy(policy)
+	if !ok {
+		panic(fmt.Sprintf("route uses undefined rate limit policy %q", policy))
+	}
+	policyHeader := fmt.Sprintf("%d;w=%d;burst=%d", p.Limit, int(p.Period.Seconds()), p.Burst)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := cfg.limiter.Allow(r.Context(), policy, cfg.rateLimitKey(r))
+		if err != nil {
+			slog.WarnContext(r.Context(), "rate limiter unavailable", "policy", policy, "error", err)
+			next(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Policy", policyHeader)
+		h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+		if !res.Allowed {
+			cfg.metrics.rateLimited.WithLabelValues(policy).Inc()
+			h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+			respondWithError(w, r, newAppError(codeRateLimited,
+				fmt.Sprintf("Rate limit exceeded; retry in %s seconds.", ceilSeconds(res.RetryAfter)), nil))
+			return
+		}
+		next(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimitKey identifies the caller. A valid token is checked here as well
+// as in the handler so that one user gets one bucket wherever they connect
+// from; an invalid one is ignored and the handler rejects it.
+func (cfg *apiConfig) rateLimitKey(r *http.Request) string {
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		if userID, err := auth.ValidateJWT(token, cfg.secret); err == nil {
+			return "user:" + userID.String()
+		}
+	}
+	return "ip:" + cfg.clientIP(r)
+}
+
+// clientIP is the address the request came from: the peer, or the last hop
+// recorded in X-Forwarded-For when a trusted proxy is in front.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	if cfg.conf.RateLimit.TrustForwardedFor {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			hops := strings.Split(xff[len(xff)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/config"
+	"github.com/05blue04/chirpy/internal/store"
+)
+
+func TestRateLimits(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	cfg.conf.RateLimit.Enabled = true
+	cfg.conf.RateLimit.Policies["signup"] = config.RateLimitPolicy{Limit: 1, Period: config.Duration(time.Hour), Burst: 2}
+	cfg.conf.RateLimit.Policies["write"] = config.RateLimitPolicy{Limit: 1, Period: config.Duration(time.Hour), Burst: 1}
+	limiter, err := newRateLimiter(cfg.conf.RateLimit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.limiter = limiter
+	c := newTestClient(t, cfg)
+
+	// Anonymous requests share the client address's bucket.
+	body := map[string]string{"email": "walt@example.com", "password": "04234"}
+	resp := c.do("POST", "/api/v1/users", "", body)
+	c.expect(resp, http.StatusCreated, nil)
+	for header, want := range map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "1",
+		"RateLimit-Reset":     "3600",
+		"RateLimit-Policy":    "1;w=3600;burst=2",
+	} {
+		if got := resp.header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	c.signup("jesse@example.com", "04234")
+
+	resp = c.do("POST", "/api/users", "", map[string]string{"email": "skyler@example.com", "password": "04234"})
+	c.expectProblem(resp, codeRateLimited)
+	if resp.header.Get("Retry-After") != "3600" || resp.header.Get("RateLimit-Remaining") != "0" {
+		t.Errorf("429 headers = %v", resp.header)
+	}
+
+	// Signed-in users get a bucket each, whatever address they use.
+	walt := c.login("walt@example.com", "04234")
+	jesse := c.login("jesse@example.com", "04234")
+	c.createChirp(walt.Token, "one")
+	c.expectProblem(c.do("POST", "/api/v1/chirps", bearer(walt.Token), map[string]string{"body": "two"}), codeRateLimited)
+	c.createChirp(jesse.Token, "mine")
+
+	if resp := c.do("GET", "/api/livez", "", nil); resp.header.Get("RateLimit-Limit") != "" {
+		t.Error("unlimited route sent RateLimit headers")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name  string
+		trust bool
+		xff   []string
+		want  string
+	}{
+		{"peer", false, nil, "192.0.2.1"},
+		{"untrusted header", false, []string{"198.51.100.7"}, "192.0.2.1"},
+		{"trusted header", true, []string{"203.0.113.9, 198.51.100.7"}, "198.51.100.7"},
+		{"last of several headers", true, []string{"203.0.113.9", "198.51.100.8"}, "198.51.100.8"},
+		{"garbage header", true, []string{"not an ip"}, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &apiConfig{}
+			cfg.conf.RateLimit.TrustForwardedFor = tt.trust
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "192.0.2.1:5555"
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := cfg.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,128 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
 
+	"github.com/05blue04/chirpy/internal/migrate"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// handlerReadiness serves the original /api/healthz, kept for existing
+// clients. It is a liveness check; use /api/readyz for readiness.
 func handlerReadiness(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(http.StatusText(http.StatusOK)))
 }
+
+// livezHandler only reports that the process is up and serving; it must not
+// depend on anything external or an outage would get every replica killed.
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, livezResponse{Status: checkOK})
+}
+
+type livezResponse struct {
+	Status string `json:"status"`
+}
+
+type readyzResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+type checkResult struct {
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Latency  string `json:"latency,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Details  any    `json:"details,omitempty"`
+}
+
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// readyzHandler reports whether this instance can serve traffic. It returns
+// 503 when any critical check fails; non-critical failures are reported but
+// leave the instance in rotation.
+func (cfg *apiConfig) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]checkResult{
+		"database":   cfg.checkDatabase(ctx),
+		"migrations": cfg.checkMigrations(ctx),
+		"workers":    cfg.checkWorkers(),
+	}
+
+	status, code := checkOK, http.StatusOK
+	for _, c := range checks {
+		if c.Status != checkOK && c.Critical {
+			status, code = checkFail, http.StatusServiceUnavailable
+		}
+	}
+
+	respondWithJSON(w, code, readyzResponse{
+		Status: status,
+		Checks: checks,
+	})
+}
+
+func (cfg *apiConfig) checkDatabase(ctx context.Context) checkResult {
+	start := time.Now()
+	err := cfg.db.Ping(ctx)
+	res := checkResult{Status: checkOK, Critical: true, Latency: time.Since(start).String()}
+	if err != nil {
+		res.Status = checkFail
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// migrationsDetails is checkMigrations' breakdown. Ahead is set when the
+// database has migrations this binary does not know about.
+type migrationsDetails struct {
+	Current  int64 `json:"current"`
+	Expected int64 `json:"expected"`
+	Ahead    bool  `json:"ahead,omitempty"`
+}
+
+// checkMigrations fails only when the schema is older than this binary
+// needs. A newer one is normal during a rolling deploy, once the first new
+// replica has migrated, and the old replicas must stay in rotation.
+func (cfg *apiConfig) checkMigrations(ctx context.Context) checkResult {
+	res := checkResult{Status: checkOK, Critical: true}
+	if cfg.conn == nil {
+		// The in-memory store has no schema to migrate.
+		return res
+	}
+	version, err := migrate.CurrentVersion(ctx, cfg.conn)
+	details := migrationsDetails{Current: version, Expected: cfg.schema, Ahead: version > cfg.schema}
+	res.Details = details
+	if err != nil {
+		res.Status = checkFail
+		res.Error = err.Error()
+	} else if version < cfg.schema {
+		res.Status = checkFail
+		res.Error = fmt.Sprintf("schema is at version %d, expected %d", version, cfg.schema)
+	}
+	return res
+}
+
+func (cfg *apiConfig) checkWorkers() checkResult {
+	res := checkResult{Status: checkOK}
+	workers := cfg.workers.Status()
+	for name, st := range workers {
+		if st.State == workerFailed {
+			res.Status = checkFail
+			res.Error = fmt.Sprintf("worker %s failed", name)
+		}
+	}
+	res.Details = workers
+	return res
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadyzSchemaVersion(t *testing.T) {
+	type migrations struct {
+		Status  string            `json:"status"`
+		Details migrationsDetails `json:"details"`
+	}
+	type readyz struct {
+		Status string `json:"status"`
+		Checks struct {
+			Migrations migrations `json:"migrations"`
+		} `json:"checks"`
+	}
+
+	for _, tc := range []struct {
+		name   string
+		offset int64
+		status int
+		ahead  bool
+	}{
+		{"current", 0, http.StatusOK, false},
+		// A new replica migrated during a rolling deploy; this one still
+		// works against the newer schema and stays in rotation.
+		{"db ahead of binary", -1, http.StatusOK, true},
+		{"db behind binary", 1, http.StatusServiceUnavailable, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := openTestConfig(t, "sqlite:"+filepath.Join(t.TempDir(), "chirpy.db"))
+			cfg.schema += tc.offset
+			c := newTestClient(t, cfg)
+
+			var ready readyz
+			c.expect(c.do("GET", "/api/readyz", "", nil), tc.status, &ready)
+			got := ready.Checks.Migrations
+			if ok := tc.status == http.StatusOK; (got.Status == checkOK) != ok {
+				t.Errorf("migrations check = %q", got.Status)
+			}
+			if got.Details.Ahead != tc.ahead || got.Details.Expected != cfg.schema {
+				t.Errorf("migrations details = %+v", got.Details)
+			}
+		})
+	}
+}
@@ -1,22 +1,20 @@
 package main
 
 import (
-	"context"
 	"net/http"
 )
 
 func (cfg *apiConfig) resetHandler(w http.ResponseWriter, r *http.Request) {
 	if cfg.platform != "dev" {
-		respondWithError(w, http.StatusForbidden, "Must have role dev to hit this endpoint", nil)
+		respondWithError(w, r, newAppError(codeForbidden, "Reset is only available when PLATFORM is dev.", nil))
 		return
 	}
 
-	err := cfg.db.ClearUsers(context.Background())
+	err := cfg.db.ClearUsers(r.Context())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error clearing users from database", err)
+		respondWithError(w, r, err)
 		return
 	}
-	cfg.fileserverHits.Store(0)
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("users table has been cleared and hits have been reset"))
+	w.Write([]byte("users table has been cleared"))
 }
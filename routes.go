@@ -0,0 +1,324 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/05blue04/chirpy/internal/config"
+	"github.com/05blue04/chirpy/internal/jobs"
+)
+
+// route is one API endpoint: how it is served and how it is described in the
+// OpenAPI spec.
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	// limit names the rate limit policy applied to the route, if any.
+	limit string
+	doc   operation
+}
+
+// undocumentedPatterns are the patterns routes registers outside the route
+// table. They serve static files, not API calls, so the spec leaves them out.
+var undocumentedPatterns = []string{"/app/", "/app/assets/"}
+
+// routes builds the full HTTP handler: every route wrapped in the
+// middleware stack.
+func (cfg *apiConfig) routes() http.Handler {
+	return chain(cfg.router(),
+		middlewareTracing,
+		middlewareRequestID,
+		middlewareAccessLog,
+		cfg.metrics.middleware,
+		cfg.middlewareCompress,
+		middlewareRecover,
+		cfg.middlewareSecurityHeaders,
+		cfg.middlewareCORS,
+		middlewareRoutePattern,
+	)
+}
+
+func (cfg *apiConfig) router() *router {
+	rt := newRouter()
+	routes := cfg.apiRoutes()
+	cfg.openAPI = mustMarshalOpenAPI(routes)
+
+	handler := http.StripPrefix("/app/", http.FileServer(http.Dir(".")))
+	rt.Handle("/app/", cfg.middlewareMetricsInc(withCacheControl(pageCacheControl, handler)))
+	rt.Handle("/app/assets/", cfg.middlewareMetricsInc(withCacheControl(assetCacheControl,
+		http.StripPrefix("/app/assets/", http.FileServer(http.Dir("./assets"))))))
+
+	for _, r := range routes {
+		rt.Handle(r.method+" "+r.path, cfg.middlewareRateLimit(r.limit, r.handler))
+	}
+	return rt
+}
+
+// apiRoutes lists every endpoint: the unversioned service routes, each API
+// version under its prefix, and the deprecated unversioned aliases. Adding
+// one here both serves it and documents it; openapi_test.go fails if a route
+// is registered any other way.
+func (cfg *apiConfig) apiRoutes() []route {
+	routes := cfg.serviceRoutes()
+	for _, v := range cfg.apiVersions() {
+		routes = append(routes, v.mount()...)
+	}
+	return append(routes, legacyAliases(cfg.v1Routes())...)
+}
+
+// serviceRoutes are the health, admin and documentation endpoints. They
+// describe the running server rather than the API, so they are not
+// versioned.
+func (cfg *apiConfig) serviceRoutes() []route {
+	return []route{
+		// health
+		{"GET", "/api/healthz", handlerReadiness, "", operation{
+			summary:     "Liveness check (legacy)",
+			description: "Plain-text liveness check kept for existing clients. Prefer /api/livez.",
+			tag:         "health",
+			status:      http.StatusOK,
+			contentType: "text/plain",
+		}},
+		{"GET", "/api/livez", livezHandler, "", operation{
+			summary:     "Liveness check",
+			description: "Reports that the process is up. Checks nothing external.",
+			tag:         "health",
+			status:      http.StatusOK,
+			response:    livezResponse{},
+		}},
+		{"GET", "/api/readyz", cfg.readyzHandler, "", operation{
+			summary:     "Readiness check",
+			description: "Checks the database, migrations and background workers. Returns 503 when a critical check fails, with the same body.",
+			tag:         "health",
+			status:      http.StatusOK,
+			response:    readyzResponse{},
+		}},
+
+		// admin
+		{"GET", "/admin/metrics", cfg.metricHandler, "", operation{
+			summary:     "Metrics page",
+			description: "HTML view of the chirpy_* metrics.",
+			tag:         "admin",
+			status:      http.StatusOK,
+			contentType: "text/html",
+		}},
+		{"GET", "/metrics", cfg.metrics.handler().ServeHTTP, "", operation{
+			summary:     "Prometheus metrics",
+			description: "Scrape endpoint in the Prometheus text exposition format.",
+			tag:         "admin",
+			status:      http.StatusOK,
+			contentType: "text/plain",
+		}},
+		{"POST", "/admin/reset", cfg.resetHandler, "", operation{
+			summary:     "Reset the database",
+			description: "Deletes every user and everything they own. Only available when PLATFORM is dev.",
+			tag:         "admin",
+			status:      http.StatusOK,
+			contentType: "text/plain",
+			errors:      []errorCode{codeForbidden},
+		}},
+		{"GET", "/admin/jobs", cfg.jobsHandler, "", operation{
+			summary:     "Background jobs",
+			description: "The most recent jobs, newest first, with how many are in each state. Dead jobs failed every attempt; last_error says why. Only available when PLATFORM is dev.",
+			tag:         "admin",
+			params: []parameter{
+				{name: "state", in: "query", description: "Only list jobs in this state.", enum: jobs.States},
+				{name: "limit", in: "query", description: "How many jobs to list, up to 500. Defaults to 50."},
+			},
+			status:   http.StatusOK,
+			response: jobsResponse{},
+			errors:   []errorCode{codeInvalidParameter, codeForbidden},
+		}},
+		{"GET", "/admin/config", cfg.configHandler, "", operation{
+			summary:     "Effective configuration",
+			description: "The configuration the server is running with. Secrets are redacted. Only available when PLATFORM is dev.",
+			tag:         "admin",
+			status:      http.StatusOK,
+			response:    config.Config{},
+			errors:      []errorCode{codeForbidden},
+		}},
+
+		// docs
+		{"GET", "/api/openapi.json", cfg.openAPIHandler, "", operation{
+			summary:     "OpenAPI document",
+			description: "This document.",
+			tag:         "docs",
+			status:      http.StatusOK,
+			contentType: "application/json",
+		}},
+		{"GET", "/api/docs", apiDocsHandler, "", operation{
+			summary:     "API reference",
+			description: "A browsable rendering of the OpenAPI document.",
+			tag:         "docs",
+			status:      http.StatusOK,
+			contentType: "text/html",
+		}},
+		{"GET", "/api/docs/viewer.js", viewerHandler("viewer.js", "text/javascript; charset=utf-8"), "", operation{
+			summary:     "API reference script",
+			description: "The script that renders the API reference page, served from the binary.",
+			tag:         "docs",
+			status:      http.StatusOK,
+			contentType: "text/javascript",
+		}},
+		{"GET", "/api/docs/viewer.css", viewerHandler("viewer.css", "text/css; charset=utf-8"), "", operation{
+			summary:     "API reference stylesheet",
+			description: "The stylesheet of the API reference page, served from the binary.",
+			tag:         "docs",
+			status:      http.StatusOK,
+			contentType: "text/css",
+		}},
+	}
+}
+
+// v1Routes lists version 1 of the API, with paths relative to its prefix.
+func (cfg *apiConfig) v1Routes() []route {
+	chirpID := parameter{
+		name:        "chirpID",
+		in:          "path",
+		description: "ID of the chirp.",
+		format:      "uuid",
+	}
+
+	return []route{
+		// users
+		{"POST", "/users", cfg.usersHandler, "signup", operation{
+			summary:  "Create a user",
+			tag:      "users",
+			request:  createUserRequest{},
+			status:   http.StatusCreated,
+			response: User{},
+			errors:   []errorCode{codeEmailTaken},
+		}},
+		{"POST", "/login", cfg.loginHandler, "auth", operation{
+			summary:     "Log in",
+			description: "Returns an access token valid for an hour and a refresh token valid for 60 days.",
+			tag:         "users",
+			request:     loginRequest{},
+			status:      http.StatusOK,
+			response:    loginResponse{},
+			errors:      []errorCode{codeInvalidCredentials},
+		}},
+		{"POST", "/refresh", cfg.refreshHandler, "auth", operation{
+			summary:  "Get a new access token",
+			tag:      "users",
+			security: securityRefreshToken,
+			status:   http.StatusOK,
+			response: refreshResponse{},
+		}},
+		{"POST", "/revoke", cfg.revokeHandler, "auth", operation{
+			summary:  "Revoke a refresh token",
+			tag:      "users",
+			security: securityRefreshToken,
+			status:   http.StatusNoContent,
+		}},
+		{"PUT", "/users", cfg.updateUserHandler, "write", operation{
+			summary:  "Update the current user",
+			tag:      "users",
+			security: securityBearer,
+			request:  updateUserRequest{},
+			status:   http.StatusOK,
+			response: User{},
+			errors:   []errorCode{codeEmailTaken},
+		}},
+		{"POST", "/users/verify", cfg.verifyEmailHandler, "auth", operation{
+			summary:     "Verify an email address",
+			description: "Takes the token from a verification link. Links are sent on signup and whenever an update leaves the address unverified, and stop working once they expire or the address changes.",
+			tag:         "users",
+			request:     verifyEmailRequest{},
+			status:      http.StatusOK,
+			response:    User{},
+			errors:      []errorCode{codeInvalidToken},
+		}},
+		{"POST", "/polka/webhooks", cfg.polkaHandler, "", operation{
+			summary:     "Polka webhook",
+			description: "Upgrades the user to Chirpy Red on a user.upgraded event; other events are acknowledged and ignored. Unknown fields are allowed.",
+			tag:         "webhooks",
+			security:    securityPolkaKey,
+			request:     polkaWebhookRequest{},
+			status:      http.StatusNoContent,
+		}},
+
+		// chirps
+		{"POST", "/chirps", cfg.createChirpHandler, "write", operation{
+			summary:     "Create a chirp",
+			description: "The words kerfuffle, sharbert and fornax are replaced with ****.",
+			tag:         "chirps",
+			security:    securityBearer,
+			request:     createChirpRequest{},
+			status:      http.StatusCreated,
+			response:    Chirp{},
+		}},
+		{"GET", "/chirps", cfg.getChirpsHandler, "read", operation{
+			summary: "List chirps",
+			tag:     "chirps",
+			params: []parameter{
+				{name: "author_id", in: "query", description: "Only return chirps by this user.", format: "uuid"},
+				{name: "sort", in: "query", description: "Order by creation time. Defaults to asc.", enum: []string{"asc", "desc"}},
+			},
+			status:      http.StatusOK,
+			response:    []Chirp{},
+			conditional: true,
+			errors:      []errorCode{codeInvalidParameter},
+		}},
+		{"GET", "/chirps/{chirpID}", cfg.getChirpByIDHandler, "read", operation{
+			summary:     "Get a chirp",
+			tag:         "chirps",
+			params:      []parameter{chirpID},
+			status:      http.StatusOK,
+			response:    Chirp{},
+			conditional: true,
+			errors:      []errorCode{codeInvalidParameter, codeNotFound},
+		}},
+		{"DELETE", "/chirps/{chirpID}", cfg.deleteChirpHandler, "write", operation{
+			summary:  "Delete a chirp",
+			tag:      "chirps",
+			security: securityBearer,
+			params:   []parameter{chirpID},
+			status:   http.StatusNoContent,
+			errors:   []errorCode{codeInvalidParameter, codeForbidden, codeNotFound},
+		}},
+		{"GET", "/stream/chirps", cfg.streamChirpsHandler, "read", operation{
+			summary:     "Stream chirp events",
+			description: "Server-sent events: chirp.created with the chirp and chirp.deleted with its id and user_id. Idle streams get a heartbeat comment. A client resuming with an ID that is no longer buffered gets a reset event and should reload the chirps.",
+			tag:         "chirps",
+			params: []parameter{
+				{name: "author_id", in: "query", description: "Only send events for chirps by this user. Repeat to follow several users.", format: "uuid"},
+				{name: "last_event_id", in: "query", description: "Resume after this event. Ignored when the Last-Event-ID header is sent."},
+				{name: "Last-Event-ID", in: "header", description: "Resume after this event, as browsers do when they reconnect."},
+			},
+			status:      http.StatusOK,
+			contentType: "text/event-stream",
+			errors:      []errorCode{codeInvalidParameter},
+		}},
+
+		// live
+		{"GET", "/ws", cfg.websocketHandler, "read", operation{
+			summary: "WebSocket API",
+			description: "Upgrades to a WebSocket carrying JSON messages. Authenticate with a bearer token in the handshake or an {\"type\": \"auth\", \"token\": ...} first message, " +
+				"then send {\"type\": \"subscribe\", \"channel\": ...} for home, notifications, user:<id> or hashtag:<tag>. " +
+				"Events arrive as {\"type\": \"event\", ...}. A reauth message asks for a new token before the current one expires; without one the socket closes with code 4002.",
+			tag:      "live",
+			security: securityBearer,
+			status:   http.StatusSwitchingProtocols,
+		}},
+	}
+}
+
+// router is an http.ServeMux that remembers the patterns registered on it.
+type router struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func newRouter() *router {
+	return &router{ServeMux: http.NewServeMux()}
+}
+
+func (rt *router) Handle(pattern string, h http.Handler) {
+	rt.patterns = append(rt.patterns, pattern)
+	rt.ServeMux.Handle(pattern, h)
+}
+
+func (rt *router) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	rt.Handle(pattern, http.HandlerFunc(h))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// middlewareSecurityHeaders sets the response headers that harden browsers
+// against sniffing, framing and content injection. A handler that needs a
+// looser Content-Security-Policy, such as the API docs page, replaces it.
+func (cfg *apiConfig) middlewareSecurityHeaders(next http.Handler) http.Handler {
+	conf := cfg.conf.Security
+	hsts := "max-age=" + strconv.Itoa(int(time.Duration(conf.HSTSMaxAge).Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		// Older browsers ignore CSP frame-ancestors.
+		h.Set("X-Frame-Options", "DENY")
+		if conf.ContentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", conf.ContentSecurityPolicy)
+		}
+		// HSTS is only honoured over HTTPS, and sending it over plain HTTP
+		// during development would be confusing.
+		if conf.HSTSMaxAge > 0 && cfg.isHTTPS(r) {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (cfg *apiConfig) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return cfg.conf.Security.TrustForwardedProto && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// middlewareCORS lets pages from the configured origins call the API. It
+// answers preflight requests itself, so they never reach the router, which
+// would reject OPTIONS. Requests from other origins are served without CORS
+// headers and the browser withholds the response.
+func (cfg *apiConfig) middlewareCORS(next http.Handler) http.Handler {
+	conf := cfg.conf.CORS
+	if len(conf.AllowedOrigins) == 0 {
+		return next
+	}
+	anyOrigin := slices.Contains(conf.AllowedOrigins, "*")
+	methods := strings.Join(conf.AllowedMethods, ", ")
+	headers := strings.Join(conf.AllowedHeaders, ", ")
+	exposed := strings.Join(conf.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(time.Duration(conf.MaxAge).Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		// The answer depends on these request headers, so caches must key
+		// on them even when no CORS headers are sent.
+		h.Add("Vary", "Origin")
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		allowed := origin != "" && (anyOrigin || slices.Contains(conf.AllowedOrigins, origin))
+		if allowed {
+			if anyOrigin && !conf.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if conf.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if !preflight {
+			if allowed && exposed != "" {
+				h.Set("Access-Control-Expose-Headers", exposed)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if allowed {
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+			h.Set("Access-Control-Max-Age", maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
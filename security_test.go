@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/05blue04/chirpy/internal/store"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	cfg.conf.Security.TrustForwardedProto = true
+	h := cfg.routes()
+
+	serve := func(path string, header map[string]string) http.Header {
+		req := httptest.NewRequest("GET", path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Header()
+	}
+
+	got := serve("/api/livez", nil)
+	for header, want := range map[string]string{
+		"X-Content-Type-Options":  "nosniff",
+		"Referrer-Policy":         "strict-origin-when-cross-origin",
+		"X-Frame-Options":         "DENY",
+		"Content-Security-Policy": cfg.conf.Security.ContentSecurityPolicy,
+	} {
+		if got.Get(header) != want {
+			t.Errorf("%s = %q, want %q", header, got.Get(header), want)
+		}
+	}
+	if got.Get("Strict-Transport-Security") != "" {
+		t.Error("HSTS sent over plain HTTP")
+	}
+
+	got = serve("/api/livez", map[string]string{"X-Forwarded-Proto": "https"})
+	if hsts := got.Get("Strict-Transport-Security"); hsts != "max-age=15552000" {
+		t.Errorf("Strict-Transport-Security = %q behind an HTTPS proxy", hsts)
+	}
+
+	if csp := serve("/api/docs", nil).Get("Content-Security-Policy"); csp != apiDocsPolicy {
+		t.Errorf("docs page Content-Security-Policy = %q, want its own", csp)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	const origin = "https://web.chirpy.example"
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		method      string
+		header      map[string]string
+		wantStatus  int
+		wantOrigin  string
+		wantMethods bool
+	}{
+		{
+			name:       "preflight",
+			origins:    []string{origin},
+			method:     "OPTIONS",
+			header:     map[string]string{"Origin": origin, "Access-Control-Request-Method": "POST"},
+			wantStatus: http.StatusNoContent, wantOrigin: origin, wantMethods: true,
+		},
+		{
+			name:       "preflight from another origin",
+			origins:    []string{origin},
+			method:     "OPTIONS",
+			header:     map[string]string{"Origin": "https://evil.example", "Access-Control-Request-Method": "POST"},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "simple request",
+			origins:    []string{origin},
+			method:     "GET",
+			header:     map[string]string{"Origin": origin},
+			wantStatus: http.StatusOK, wantOrigin: origin,
+		},
+		{
+			name:       "any origin",
+			origins:    []string{"*"},
+			method:     "GET",
+			header:     map[string]string{"Origin": origin},
+			wantStatus: http.StatusOK, wantOrigin: "*",
+		},
+		{
+			name:        "credentials echo the origin",
+			origins:     []string{origin},
+			credentials: true,
+			method:      "GET",
+			header:      map[string]string{"Origin": origin},
+			wantStatus:  http.StatusOK, wantOrigin: origin,
+		},
+		{
+			name:       "disabled",
+			method:     "OPTIONS",
+			header:     map[string]string{"Origin": origin, "Access-Control-Request-Method": "POST"},
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+			cfg.conf.CORS.AllowedOrigins = tt.origins
+			cfg.conf.CORS.AllowCredentials = tt.credentials
+
+			req := httptest.NewRequest(tt.method, "/api/v1/chirps", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			cfg.routes().ServeHTTP(rec, req)
+			got := rec.Header()
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if o := got.Get("Access-Control-Allow-Origin"); o != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", o, tt.wantOrigin)
+			}
+			if (got.Get("Access-Control-Allow-Methods") != "") != tt.wantMethods {
+				t.Errorf("Access-Control-Allow-Methods = %q", got.Get("Access-Control-Allow-Methods"))
+			}
+			if creds := got.Get("Access-Control-Allow-Credentials") == "true"; creds != tt.credentials {
+				t.Errorf("Access-Control-Allow-Credentials = %v, want %v", creds, tt.credentials)
+			}
+			if tt.wantOrigin != "" && tt.method == "GET" && got.Get("Access-Control-Expose-Headers") == "" {
+				t.Error("simple request has no Access-Control-Expose-Headers")
+			}
+			if tt.origins != nil && got.Get("Vary") == "" {
+				t.Error("response does not Vary on Origin")
+			}
+		})
+	}
+}
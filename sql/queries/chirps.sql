@@ -9,6 +9,9 @@ SELECT * FROM chirps ORDER BY created_at ASC;
 -- name: GetChirpById :one
 SELECT * FROM chirps WHERE id = $1;
 
+-- name: GetChirpByIdForUpdate :one
+SELECT * FROM chirps WHERE id = $1 FOR UPDATE;
+
 -- name: DeleteChirpByID :exec
 DELETE FROM chirps
 WHERE id = $1;
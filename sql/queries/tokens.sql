@@ -2,6 +2,19 @@
 INSERT INTO refresh_tokens(token,created_at,updated_at,user_id,expires_at,revoked_at)
 VALUES($1, $2, $3, $4, $5, $6);
 
+-- name: DeleteStaleTokens :execrows
+-- Deletes up to batch_size tokens that expired before expired_before or were
+-- revoked before revoked_before. Rows another transaction holds are left for
+-- the next batch.
+DELETE FROM refresh_tokens
+WHERE token IN (
+    SELECT token FROM refresh_tokens
+    WHERE expires_at < sqlc.arg(expired_before)
+       OR revoked_at < sqlc.arg(revoked_before)::timestamp
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+);
+
 -- name: GetTokenByID :one
 SELECT * FROM refresh_tokens 
 WHERE token = $1 AND revoked_at IS NULL;
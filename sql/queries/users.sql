@@ -10,10 +10,16 @@ DELETE FROM users;
 SELECT * FROM users 
 WHERE email = $1;
 
+-- name: GetUserByIDForUpdate :one
+SELECT * FROM users
+WHERE id = $1 FOR UPDATE;
+
 
 -- name: UpdateUser :one
+-- A new email address has to be verified again.
 UPDATE users
-SET email = $1, hashed_password = $2, updated_at = now()
+SET email = $1, hashed_password = $2, updated_at = now(),
+    email_verified_at = CASE WHEN email = $1 THEN email_verified_at END
 WHERE id = $3
 RETURNING *;
 
@@ -21,3 +27,11 @@ RETURNING *;
 UPDATE users
 SET is_chirpy_red = true
 WHERE id = $1;
+
+-- name: VerifyUserEmail :one
+-- Marks the email verified if it is still the user's address. Verifying again
+-- keeps the first time.
+UPDATE users
+SET email_verified_at = COALESCE(email_verified_at, now()), updated_at = now()
+WHERE id = $1 AND email = $2
+RETURNING *;
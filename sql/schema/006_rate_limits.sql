@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE rate_limit_buckets(
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX rate_limit_buckets_updated_at_idx ON rate_limit_buckets(updated_at);
+
+-- +goose Down
+DROP TABLE rate_limit_buckets;
@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE jobs(
+    id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    state TEXT NOT NULL DEFAULT 'pending'
+        CHECK (state IN ('pending', 'running', 'done', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ,
+    last_error TEXT,
+    -- Set for jobs that must be enqueued at most once, such as each
+    -- occurrence of a scheduled job.
+    unique_key TEXT UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+-- Workers look for due pending jobs and running jobs whose lease expired.
+CREATE INDEX jobs_due_idx ON jobs(run_at, id) WHERE state IN ('pending', 'running');
+CREATE INDEX jobs_state_idx ON jobs(state, updated_at);
+
+-- +goose Down
+DROP TABLE jobs;
@@ -0,0 +1,23 @@
+-- name: CreateChirp :one
+INSERT INTO chirps(id, created_at, updated_at, body ,user_id)
+VALUES(?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetChirps :many
+SELECT * FROM chirps ORDER BY created_at ASC;
+
+-- name: GetChirpById :one
+SELECT * FROM chirps WHERE id = ?;
+
+-- name: GetChirpByIdForUpdate :one
+-- SQLite has no row locks; the transaction's database lock serves instead.
+SELECT * FROM chirps WHERE id = ?;
+
+-- name: DeleteChirpByID :exec
+DELETE FROM chirps
+WHERE id = ?;
+
+-- name: GetChirpsByAuthID :many
+SELECT * FROM chirps 
+WHERE user_id = ? 
+ORDER BY created_at ASC;
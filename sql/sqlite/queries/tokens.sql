@@ -0,0 +1,23 @@
+-- name: CreateToken :exec
+INSERT INTO refresh_tokens(token,created_at,updated_at,user_id,expires_at,revoked_at)
+VALUES(?, ?, ?, ?, ?, ?);
+
+-- name: DeleteStaleTokens :execrows
+-- Deletes up to batch_size tokens that expired before expired_before or were
+-- revoked before revoked_before.
+DELETE FROM refresh_tokens
+WHERE token IN (
+    SELECT token FROM refresh_tokens
+    WHERE expires_at < sqlc.arg(expired_before)
+       OR revoked_at < sqlc.arg(revoked_before)
+    LIMIT sqlc.arg(batch_size)
+);
+
+-- name: GetTokenByID :one
+SELECT * FROM refresh_tokens 
+WHERE token = ? AND revoked_at IS NULL;
+
+-- name: RevokeToken :exec
+UPDATE refresh_tokens
+SET revoked_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'), updated_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')
+WHERE token = ?; 
@@ -0,0 +1,40 @@
+-- name: CreateUser :one
+INSERT INTO users (id,created_at,updated_at,email,hashed_password)
+VALUES(?, ?, ? , ?, ?)
+RETURNING *;
+
+-- name: ClearUsers :exec
+DELETE FROM users;
+
+-- name: GetUserByEmail :one
+SELECT * FROM users 
+WHERE email = ?;
+
+-- name: GetUserByIDForUpdate :one
+-- SQLite has no row locks; the transaction's database lock serves instead.
+SELECT * FROM users
+WHERE id = ?;
+
+
+-- name: UpdateUser :one
+-- A new email address has to be verified again.
+UPDATE users
+SET email = sqlc.arg(email), hashed_password = sqlc.arg(hashed_password),
+    updated_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'),
+    email_verified_at = CASE WHEN email = sqlc.arg(email) THEN email_verified_at END
+WHERE id = sqlc.arg(id)
+RETURNING *;
+
+-- name: UpdateUserToRed :exec
+UPDATE users
+SET is_chirpy_red = true
+WHERE id = ?;
+
+-- name: VerifyUserEmail :one
+-- Marks the email verified if it is still the user's address. Verifying again
+-- keeps the first time.
+UPDATE users
+SET email_verified_at = COALESCE(email_verified_at, strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    updated_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')
+WHERE id = ? AND email = ?
+RETURNING *;
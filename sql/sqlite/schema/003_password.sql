@@ -0,0 +1,8 @@
+-- +goose Up
+-- SQLite can only add a NOT NULL column with a default.
+ALTER TABLE users
+ADD COLUMN hashed_password TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN hashed_password;
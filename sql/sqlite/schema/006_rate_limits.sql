@@ -0,0 +1,11 @@
+-- +goose Up
+-- Only the Postgres rate limiter stores buckets; SQLite deployments are
+-- single-node and keep them in memory. The table keeps the schemas in step.
+CREATE TABLE rate_limit_buckets(
+    key TEXT PRIMARY KEY,
+    tokens REAL NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE rate_limit_buckets;
@@ -0,0 +1,8 @@
+// Package schema embeds the goose migrations for the SQLite backend. They
+// mirror sql/schema version for version.
+package schema
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
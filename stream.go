@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/05blue04/chirpy/internal/config"
+	"github.com/05blue04/chirpy/internal/pubsub"
+	"github.com/google/uuid"
+)
+
+// Event types published to the hub. The chirp stream sends the chirp
+// events; the WebSocket API sends all of them.
+const (
+	eventChirpCreated = "chirp.created"
+	eventChirpDeleted = "chirp.deleted"
+	eventUserUpgraded = "user.upgraded"
+)
+
+// chirpDeletedEvent is the data of a chirp.deleted event.
+type chirpDeletedEvent struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+// userUpgradedEvent is the data of a user.upgraded event.
+type userUpgradedEvent struct {
+	UserID uuid.UUID `json:"user_id"`
+}
+
+// newEventBus builds the bus described by conf, delivering to hub. A
+// Postgres bus's listener still has to be started with its Run method.
+func newEventBus(conf config.Stream, db *sql.DB, dbURL string, hub *pubsub.Hub) pubsub.Bus {
+	if conf.Bus == "postgres" {
+		return pubsub.NewPostgres(db, dbURL, hub)
+	}
+	return pubsub.NewMemory(hub)
+}
+
+// publish sends e to subscribers on every replica with data as its JSON
+// payload. The change it describes has already been made, so a failure is
+// logged rather than returned.
+func (cfg *apiConfig) publish(ctx context.Context, e pubsub.Event, data any) {
+	dat, err := json.Marshal(data)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error marshalling event", "type", e.Type, "error", err)
+		return
+	}
+	e.Data = dat
+	if err := cfg.bus.Publish(ctx, e); err != nil {
+		slog.ErrorContext(ctx, "Error publishing event", "type", e.Type, "error", err)
+	}
+}
+
+// chirpEvent is an event of the given type about c.
+func chirpEvent(eventType string, c Chirp) pubsub.Event {
+	return pubsub.Event{Type: eventType, Author: c.UserID, Tags: hashtags(c.Body)}
+}
+
+func isChirpEvent(e pubsub.Event) bool {
+	return strings.HasPrefix(e.Type, "chirp.")
+}
+
+// hashtags returns the distinct hashtags in body, lowercased and without
+// the #. Trailing punctuation is not part of a tag.
+func hashtags(body string) []string {
+	var tags []string
+	for _, word := range strings.Fields(body) {
+		tag, ok := strings.CutPrefix(word, "#")
+		tag = strings.TrimRightFunc(tag, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+		})
+		if !ok || tag == "" {
+			continue
+		}
+		if tag = strings.ToLower(tag); !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// streamChirpsHandler sends chirps as they are created and deleted, as
+// server-sent events. A client that reconnects with Last-Event-ID first gets
+// the events it missed, or a reset event if they are no longer buffered.
+func (cfg *apiConfig) streamChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	authors := map[uuid.UUID]bool{}
+	for _, s := range r.URL.Query()["author_id"] {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			respondWithError(w, r, errInvalidUUID("author_id", err))
+			return
+		}
+		authors[id] = true
+	}
+	match := func(e pubsub.Event) bool {
+		return isChirpEvent(e) && (len(authors) == 0 || authors[e.Author])
+	}
+
+	// Browsers send Last-Event-ID when they reconnect; the query parameter
+	// lets a page resume from an ID it saved itself.
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+
+	rc := http.NewResponseController(w)
+	// The server's write timeout is meant for ordinary responses and would
+	// cut the stream off.
+	rc.SetWriteDeadline(time.Time{})
+
+	sub, missed := cfg.hub.Subscribe(lastID, match)
+	defer sub.Close()
+	cfg.metrics.streamClients.WithLabelValues("sse").Inc()
+	defer cfg.metrics.streamClients.WithLabelValues("sse").Dec()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	// Stop nginx from buffering the stream.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, e := range missed {
+		writeEvent(w, e)
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(time.Duration(cfg.conf.Stream.Heartbeat))
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			// A closed subscription means the server is shutting down or
+			// the client fell too far behind; either way it reconnects.
+			if !ok {
+				return
+			}
+			writeEvent(w, e)
+		case <-heartbeat.C:
+			io.WriteString(w, ": heartbeat\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes e in the text/event-stream format. Its data is compact
+// JSON, which never contains a newline.
+func writeEvent(w io.Writer, e pubsub.Event) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+}
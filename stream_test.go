@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/config"
+	"github.com/05blue04/chirpy/internal/store"
+)
+
+func TestStreamHeartbeat(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	cfg.conf.Stream.Heartbeat = config.Duration(10 * time.Millisecond)
+	c := newTestClient(t, cfg)
+
+	events := c.stream("/api/v1/stream/chirps", nil)
+	select {
+	case e := <-events:
+		if !e.comment {
+			t.Errorf("idle stream sent %+v, want a heartbeat comment", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no heartbeat")
+	}
+
+	// Closing the hub, as shutdown does, ends the stream.
+	cfg.hub.Close()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("stream still open after the hub closed")
+		}
+	}
+}
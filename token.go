@@ -2,43 +2,71 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/05blue04/chirpy/internal/auth"
+	"github.com/05blue04/chirpy/internal/database"
+	"github.com/google/uuid"
 )
 
-func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, r *http.Request) {
-
-	type response struct {
-		Token string `json:"token"`
+// authenticate returns the user named by the request's bearer JWT and
+// records them on the request for logging.
+func (cfg *apiConfig) authenticate(r *http.Request) (uuid.UUID, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil, errMissingBearer(err)
+	}
+	userID, err := auth.ValidateJWT(token, cfg.secret)
+	if err != nil {
+		return uuid.Nil, newAppError(codeInvalidToken, "Access token is invalid or has expired.", err)
 	}
+	setRequestUser(r, userID)
+	return userID, nil
+}
+
+func errMissingBearer(cause error) *appError {
+	return newAppError(codeUnauthenticated, "Send a token as 'Authorization: Bearer <token>'.", cause)
+}
+
+type refreshResponse struct {
+	Token string `json:"token"`
+}
 
+func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, 401, "unable to get token from header", err)
+		respondWithError(w, r, errMissingBearer(err))
 		return
 	}
 
-	refreshToken, err := cfg.db.GetTokenByID(context.Background(), token)
-
+	refreshToken, err := cfg.db.GetTokenByID(r.Context(), token)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, newAppError(codeInvalidToken, "Refresh token is invalid or has been revoked.", err))
+		return
+	}
 	if err != nil {
-		respondWithError(w, 401, "not authorized boy", err)
+		respondWithError(w, r, err)
 		return
 	}
 
 	if time.Now().After(refreshToken.ExpiresAt) {
-		respondWithError(w, 401, "refresh token has expired", nil)
+		respondWithError(w, r, newAppError(codeInvalidToken, "Refresh token has expired.", nil))
 		return
 	}
+	setRequestUser(r, refreshToken.UserID)
 
 	accessToken, err := auth.MakeJWT(refreshToken.UserID, cfg.secret, time.Hour)
 	if err != nil {
-		respondWithError(w, 500, "error creating New access Token", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	respondWithJSON(w, 200, response{
+	respondWithJSON(w, 200, refreshResponse{
 		Token: accessToken,
 	})
 
@@ -47,16 +75,48 @@ func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, r *http.Request) {
 func (cfg *apiConfig) revokeHandler(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, 401, "unable to get token from header", err)
+		respondWithError(w, r, errMissingBearer(err))
 		return
 	}
 
-	err = cfg.db.RevokeToken(context.Background(), token)
-
+	err = cfg.db.RevokeToken(r.Context(), token)
 	if err != nil {
-		respondWithError(w, 401, "The token provided doesn't match our records", err)
+		respondWithError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// jobSweepTokens is the kind of the scheduled job that runs sweepTokens.
+const jobSweepTokens = "sweep-refresh-tokens"
+
+// sweepTokens deletes refresh tokens that have expired or were revoked
+// longer ago than the retention period. It deletes a batch at a time until a
+// batch comes back short, so each statement holds its locks only briefly.
+func (cfg *apiConfig) sweepTokens(ctx context.Context, _ json.RawMessage) error {
+	conf := cfg.conf.TokenSweep
+	now := time.Now()
+	arg := database.DeleteStaleTokensParams{
+		ExpiredBefore: now,
+		RevokedBefore: now.Add(-time.Duration(conf.RevokedRetention)),
+		BatchSize:     int32(conf.BatchSize),
+	}
+	var total int64
+	for {
+		n, err := cfg.db.DeleteStaleTokens(ctx, arg)
+		if err != nil {
+			return err
+		}
+		total += n
+		cfg.metrics.tokensDeleted.Add(float64(n))
+		if n < int64(arg.BatchSize) {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	slog.InfoContext(ctx, "swept refresh tokens", "deleted", total)
+	return nil
+}
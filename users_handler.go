@@ -1,136 +1,198 @@
 package main
 
 import (
-	"context"
 	"database/sql"
-	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/05blue04/chirpy/internal/auth"
 	"github.com/05blue04/chirpy/internal/database"
+	"github.com/05blue04/chirpy/internal/pubsub"
+	"github.com/05blue04/chirpy/internal/store"
+	"github.com/05blue04/chirpy/internal/validate"
 	"github.com/google/uuid"
 )
 
+// errCredentialsChanged means the user's password changed, or the user was
+// deleted, while they were logging in.
+var errCredentialsChanged = errors.New("credentials changed during login")
+
+func errInvalidCredentials(cause error) *appError {
+	return newAppError(codeInvalidCredentials, "Email or password is incorrect.", cause)
+}
+
+// errEmailTaken reports a unique violation from creating or updating a user.
+// Only those queries can violate the email constraint; a unique violation
+// anywhere else is a bug and stays internal.
+func errEmailTaken(cause error) *appError {
+	return newAppError(codeEmailTaken, "An account with that email already exists.", cause)
+}
+
 type User struct {
 	ID            uuid.UUID `json:"id"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 	Email         string    `json:"email"`
 	Is_chirpy_red bool      `json:"is_chirpy_red"`
+	EmailVerified bool      `json:"email_verified"`
 }
 
-func (cfg *apiConfig) usersHandler(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
-	}
+type createUserRequest struct {
+	Password string `json:"password" validate:"required,max=72"`
+	Email    string `json:"email" validate:"required,email,max=254"`
+}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
+type loginRequest struct {
+	Password           string `json:"password" validate:"required"`
+	Email              string `json:"email" validate:"required"`
+	Expires_in_seconds int    `json:"expires_in_seconds"`
+}
 
-	err := decoder.Decode(&params)
+type loginResponse struct {
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	Token         string    `json:"token"`
+	RefreshToken  string    `json:"refresh_token"`
+	Is_chirpy_red bool      `json:"is_chirpy_red"`
+	EmailVerified bool      `json:"email_verified"`
+}
+
+type updateUserRequest struct {
+	Password string `json:"password" validate:"required,max=72"`
+	Email    string `json:"email" validate:"required,email,max=254"`
+}
+
+// polkaWebhookRequest carries no validate tags: Polka sends events we do not
+// handle, which need not have a user ID and must still be acknowledged, so
+// polkaHandler checks the user ID only for user.upgraded.
+type polkaWebhookRequest struct {
+	Event string `json:"event"`
+	Data  struct {
+		UserID string `json:"user_id"`
+	} `json:"data"`
+}
+
+func (cfg *apiConfig) usersHandler(w http.ResponseWriter, r *http.Request) {
+	params := createUserRequest{}
+	err := cfg.decodeJSON(w, r, &params)
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
-		respondWithError(w, 400, "couldn't decode parameters", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	hash, err := auth.HashPassword(params.Password)
+	hash, err := auth.HashPasswordContext(r.Context(), params.Password)
 	if err != nil {
-		respondWithError(w, 500, "couldn't create hash for password", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	u, err := cfg.db.CreateUser(context.Background(), database.CreateUserParams{
+	u, err := cfg.db.CreateUser(r.Context(), database.CreateUserParams{
 		ID:             uuid.New(),
 		CreatedAt:      time.Now(),
 		UpdatedAt:      time.Now(),
 		Email:          params.Email,
 		HashedPassword: hash,
 	})
-
+	if store.IsUniqueViolation(err) {
+		respondWithError(w, r, errEmailTaken(err))
+		return
+	}
 	if err != nil {
-		respondWithError(w, 400, "error creating user", err)
+		respondWithError(w, r, err)
 		return
 	}
 
+	// The account exists either way, so a failure to queue the mail is
+	// logged rather than returned.
+	if err := cfg.queueVerification(r.Context(), u); err != nil {
+		slog.ErrorContext(r.Context(), "Error queueing verification email", "error", err)
+	}
+
 	newUsr := User{
 		ID:            u.ID,
 		CreatedAt:     u.CreatedAt,
 		UpdatedAt:     u.UpdatedAt,
 		Email:         u.Email,
 		Is_chirpy_red: u.IsChirpyRed,
+		EmailVerified: u.EmailVerifiedAt.Valid,
 	}
 
 	respondWithJSON(w, 201, newUsr)
 }
 
 func (cfg *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Password           string `json:"password"`
-		Email              string `json:"email"`
-		Expires_in_seconds int    `json:"expires_in_seconds"`
-	}
-
-	type response struct {
-		ID            uuid.UUID `json:"id"`
-		CreatedAt     time.Time `json:"created_at"`
-		UpdatedAt     time.Time `json:"updated_at"`
-		Email         string    `json:"email"`
-		Token         string    `json:"token"`
-		RefreshToken  string    `json:"refresh_token"`
-		Is_chirpy_red bool      `json:"is_chirpy_red"`
-	}
-
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-
-	err := decoder.Decode(&params)
+	params := loginRequest{}
+	err := cfg.decodeJSON(w, r, &params)
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
-		respondWithError(w, 400, "couldn't decode parameters", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	u, err := cfg.db.GetUserByEmail(context.Background(), params.Email)
+	u, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		cfg.metrics.logins.WithLabelValues("failure").Inc()
+		respondWithError(w, r, errInvalidCredentials(err))
+		return
+	}
 	if err != nil {
-		respondWithError(w, 400, "Problem getting user via email", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, u.HashedPassword)
+	err = auth.CheckPasswordHashContext(r.Context(), params.Password, u.HashedPassword)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "password doesn't match our records", err)
+		cfg.metrics.logins.WithLabelValues("failure").Inc()
+		respondWithError(w, r, errInvalidCredentials(err))
 		return
 	}
+	setRequestUser(r, u.ID)
 
 	token, err := auth.MakeJWT(u.ID, cfg.secret, time.Hour)
 	if err != nil {
-		respondWithError(w, 500, "issues generating JWT token for user", err)
+		respondWithError(w, r, err)
 		return
 	}
 
 	refreshToken := auth.MakeRefreshToken()
 
-	err = cfg.db.CreateToken(context.Background(), database.CreateTokenParams{
-		Token:     refreshToken,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-		UserID:    u.ID,
-		ExpiresAt: time.Now().Add(24 * time.Hour * 60),
-		RevokedAt: sql.NullTime{
-			Valid: false,
-		},
+	// Only store the refresh token if the password just checked is still the
+	// user's. The row lock holds off a concurrent update or reset until the
+	// token is in.
+	err = cfg.db.WithTx(r.Context(), func(q database.Querier) error {
+		current, err := q.GetUserByIDForUpdate(r.Context(), u.ID)
+		if err != nil {
+			return err
+		}
+		if current.HashedPassword != u.HashedPassword {
+			return errCredentialsChanged
+		}
+		return q.CreateToken(r.Context(), database.CreateTokenParams{
+			Token:     refreshToken,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+			UserID:    u.ID,
+			ExpiresAt: time.Now().Add(24 * time.Hour * 60),
+			RevokedAt: sql.NullTime{
+				Valid: false,
+			},
+		})
 	})
-
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errCredentialsChanged) {
+		cfg.metrics.logins.WithLabelValues("failure").Inc()
+		respondWithError(w, r, errInvalidCredentials(err))
+		return
+	}
 	if err != nil {
-		respondWithError(w, 500, "issue generating Refresh token", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, response{
+	cfg.metrics.logins.WithLabelValues("success").Inc()
+	respondWithJSON(w, http.StatusOK, loginResponse{
 		ID:            u.ID,
 		CreatedAt:     u.CreatedAt,
 		UpdatedAt:     u.UpdatedAt,
@@ -138,87 +200,73 @@ func (cfg *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
 		Token:         token,
 		RefreshToken:  refreshToken,
 		Is_chirpy_red: u.IsChirpyRed,
+		EmailVerified: u.EmailVerifiedAt.Valid,
 	})
 }
 
 func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
-	}
-
-	token, err := auth.GetBearerToken(r.Header)
+	userID, err := cfg.authenticate(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "error extracting bearer from request", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.secret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "unable to grant access", err)
-		return
-	}
-
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-
-	err = decoder.Decode(&params)
+	params := updateUserRequest{}
+	err = cfg.decodeJSON(w, r, &params)
 	if err != nil {
-		respondWithError(w, 400, "Error decoding body", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	hash, err := auth.HashPassword(params.Password)
+	hash, err := auth.HashPasswordContext(r.Context(), params.Password)
 	if err != nil {
-		respondWithError(w, 400, "error creating password hash", err)
+		respondWithError(w, r, err)
 		return
 	}
 
-	u, err := cfg.db.UpdateUser(context.Background(), database.UpdateUserParams{
+	u, err := cfg.db.UpdateUser(r.Context(), database.UpdateUserParams{
 		HashedPassword: hash,
 		Email:          params.Email,
 		ID:             userID,
 	})
+	if store.IsUniqueViolation(err) {
+		respondWithError(w, r, errEmailTaken(err))
+		return
+	}
 	if err != nil {
-		respondWithError(w, 400, "Error updating user data", err)
+		respondWithError(w, r, err)
 		return
 	}
 
+	// A changed address is no longer verified. Sending a link whenever the
+	// address is unverified also gives users who lost theirs a new one.
+	if !u.EmailVerifiedAt.Valid {
+		if err := cfg.queueVerification(r.Context(), u); err != nil {
+			slog.ErrorContext(r.Context(), "Error queueing verification email", "error", err)
+		}
+	}
+
 	respondWithJSON(w, 200, User{
 		ID:            userID,
 		CreatedAt:     u.CreatedAt,
 		UpdatedAt:     u.UpdatedAt,
 		Email:         u.Email,
 		Is_chirpy_red: u.IsChirpyRed,
+		EmailVerified: u.EmailVerifiedAt.Valid,
 	})
 
 }
 
 func (cfg *apiConfig) polkaHandler(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID uuid.UUID `json:"user_id"`
-		} `json:"data"`
-	}
-
 	key, err := auth.GetAPIKey(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "apiKey doesn't match", err)
+	if err != nil || key != cfg.apiKey {
+		respondWithError(w, r, newAppError(codeUnauthenticated, "Send the Polka API key as 'Authorization: ApiKey <key>'.", err))
 		return
 	}
-
-	if key != cfg.apiKey {
-		respondWithError(w, http.StatusUnauthorized, "apiKey doesn't match", err)
-		return
-	}
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-
-	err = decoder.Decode(&params)
+	params := polkaWebhookRequest{}
+	err = cfg.decodeJSONAllowUnknown(w, r, &params)
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
-		respondWithError(w, 400, "couldn't decode parameters", err)
+		respondWithError(w, r, err)
 		return
 	}
 
@@ -227,11 +275,21 @@ func (cfg *apiConfig) polkaHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.UpdateUserToRed(r.Context(), params.Data.UserID)
+	userID, err := uuid.Parse(params.Data.UserID)
+	if err != nil {
+		fe := validate.FieldError{Field: "data.user_id", Rule: "uuid", Message: "must be a UUID"}
+		if params.Data.UserID == "" {
+			fe.Rule, fe.Message = "required", "is required"
+		}
+		respondWithError(w, r, errValidation(validate.Errors{fe}))
+		return
+	}
+	err = cfg.db.UpdateUserToRed(r.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "unable to find user", err)
+		respondWithError(w, r, err)
 		return
 	}
+	cfg.publish(r.Context(), pubsub.Event{Type: eventUserUpgraded, Author: userID}, userUpgradedEvent{UserID: userID})
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersion is one version of the public API, served under its own prefix.
+// A version that changes response shapes gets a new entry with its own route
+// list, served side by side with the old one; routes whose behaviour is
+// unchanged can reuse the previous version's handlers.
+type apiVersion struct {
+	prefix string
+	routes []route
+}
+
+func (cfg *apiConfig) apiVersions() []apiVersion {
+	return []apiVersion{
+		{prefix: "/api/v1", routes: cfg.v1Routes()},
+	}
+}
+
+// mount returns v's routes with their paths under v's prefix.
+func (v apiVersion) mount() []route {
+	routes := make([]route, len(v.routes))
+	for i, r := range v.routes {
+		r.path = v.prefix + r.path
+		routes[i] = r
+	}
+	return routes
+}
+
+const (
+	// legacyPrefix served the API before it was versioned. Its routes are
+	// aliases of the legacySuccessor version.
+	legacyPrefix    = "/api"
+	legacySuccessor = "/api/v1"
+)
+
+var (
+	legacyDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	legacySunsetAt     = time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC)
+)
+
+// legacyAliases serves routes, the legacy successor's, under the legacy
+// prefix, marked as deprecated.
+func legacyAliases(routes []route) []route {
+	aliases := make([]route, len(routes))
+	for i, r := range routes {
+		r.handler = deprecated(r.handler)
+		r.path = legacyPrefix + r.path
+		r.doc.deprecated = true
+		r.doc.description = strings.TrimSpace(fmt.Sprintf(
+			"Deprecated alias of %s %s%s, removed after %s. %s",
+			r.method, legacySuccessor, strings.TrimPrefix(r.path, legacyPrefix),
+			legacySunsetAt.Format(time.DateOnly), r.doc.description))
+		aliases[i] = r
+	}
+	return aliases
+}
+
+// deprecated announces that the legacy path next is served on will go away
+// (RFC 9745 and RFC 8594) and links to the same route in the successor
+// version.
+func deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Deprecation", "@"+strconv.FormatInt(legacyDeprecatedAt.Unix(), 10))
+		h.Set("Sunset", legacySunsetAt.Format(http.TimeFormat))
+		successor := legacySuccessor + strings.TrimPrefix(r.URL.Path, legacyPrefix)
+		h.Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		next(w, r)
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/auth"
+	"github.com/05blue04/chirpy/internal/pubsub"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/google/uuid"
+)
+
+const (
+	// wsAuthTimeout is how long a client that didn't send a token with the
+	// handshake has to authenticate.
+	wsAuthTimeout = 10 * time.Second
+	// wsReauthWarning is how long before the access token expires the
+	// client is asked for a new one.
+	wsReauthWarning = time.Minute
+	// wsWriteTimeout bounds each message sent. A client that can't take
+	// one in that time is disconnected.
+	wsWriteTimeout = 10 * time.Second
+	// wsReadLimit is the largest message a client may send.
+	wsReadLimit = 4096
+	// wsMaxChannels caps the subscriptions on one connection.
+	wsMaxChannels = 50
+)
+
+// Close codes in the range RFC 6455 leaves to applications.
+const (
+	wsCloseUnauthenticated websocket.StatusCode = 4001
+	wsCloseTokenExpired    websocket.StatusCode = 4002
+)
+
+// wsRequest is a message from the client.
+type wsRequest struct {
+	Type    string `json:"type"`
+	Token   string `json:"token,omitempty"`
+	Channel string `json:"channel,omitempty"`
+}
+
+// wsMessage is a message to the client. Which fields are set depends on
+// Type.
+type wsMessage struct {
+	Type      string          `json:"type"`
+	Channel   string          `json:"channel,omitempty"`
+	Channels  []string        `json:"channels,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Event     string          `json:"event,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	UserID    uuid.UUID       `json:"user_id,omitzero"`
+	ExpiresAt time.Time       `json:"expires_at,omitzero"`
+	Code      errorCode       `json:"code,omitempty"`
+	Message   string          `json:"message,omitempty"`
+}
+
+// wsChannel is something a client can subscribe to:
+//
+//	home           every chirp (there are no follows yet)
+//	user:<id>      chirps by one user
+//	hashtag:<tag>  chirps with a hashtag
+//	notifications  events about the client's own account
+type wsChannel struct {
+	kind string
+	user uuid.UUID
+	tag  string
+}
+
+func parseChannel(name string) (wsChannel, error) {
+	kind, arg, _ := strings.Cut(name, ":")
+	switch {
+	case name == "home" || name == "notifications":
+		return wsChannel{kind: name}, nil
+	case kind == "user":
+		id, err := uuid.Parse(arg)
+		if err != nil {
+			return wsChannel{}, fmt.Errorf("%q is not a valid user ID", arg)
+		}
+		return wsChannel{kind: kind, user: id}, nil
+	case kind == "hashtag":
+		tag := strings.ToLower(strings.TrimPrefix(arg, "#"))
+		if tags := hashtags("#" + tag); len(tags) != 1 || tags[0] != tag {
+			return wsChannel{}, fmt.Errorf("%q is not a valid hashtag", arg)
+		}
+		return wsChannel{kind: kind, tag: tag}, nil
+	}
+	return wsChannel{}, fmt.Errorf("unknown channel %q; use home, notifications, user:<id> or hashtag:<tag>", name)
+}
+
+func (ch wsChannel) String() string {
+	switch ch.kind {
+	case "user":
+		return "user:" + ch.user.String()
+	case "hashtag":
+		return "hashtag:" + ch.tag
+	}
+	return ch.kind
+}
+
+func (ch wsChannel) matches(e pubsub.Event, self uuid.UUID) bool {
+	switch ch.kind {
+	case "home":
+		return isChirpEvent(e)
+	case "user":
+		return isChirpEvent(e) && e.Author == ch.user
+	case "hashtag":
+		return isChirpEvent(e) && slices.Contains(e.Tags, ch.tag)
+	case "notifications":
+		return !isChirpEvent(e) && e.Author == self
+	}
+	return false
+}
+
+// websocketHandler serves the WebSocket API. A client authenticates with a
+// bearer token, either in the handshake or as its first message, then
+// subscribes to channels and receives their events. Before the token expires
+// the client is asked for a fresh one and is disconnected if it doesn't
+// send it.
+func (cfg *apiConfig) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	client := &wsClient{
+		cfg:      cfg,
+		channels: map[string]wsChannel{},
+		reauthed: make(chan struct{}, 1),
+	}
+	// Browsers can't set headers on a WebSocket handshake, so the token is
+	// optional here, but one that is sent must be good.
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		if err := client.authenticate(token); err != nil {
+			respondWithError(w, r, err)
+			return
+		}
+		setRequestUser(r, client.userID)
+	}
+
+	// The server's timeouts are meant for ordinary requests; the
+	// connection keeps its deadlines after it is hijacked.
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	conn, err := websocket.Accept(w, r, cfg.websocketOptions())
+	if err != nil {
+		// Accept has already responded.
+		return
+	}
+	conn.SetReadLimit(wsReadLimit)
+	client.conn = conn
+
+	cfg.metrics.streamClients.WithLabelValues("websocket").Inc()
+	defer cfg.metrics.streamClients.WithLabelValues("websocket").Dec()
+
+	// The request's context ends with the handler, but its values are
+	// still wanted in logs.
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	defer cancel()
+	status, reason := client.run(ctx)
+	conn.Close(status, reason)
+}
+
+// websocketOptions allows handshakes from the origins CORS allows, as well
+// as from the server's own.
+func (cfg *apiConfig) websocketOptions() *websocket.AcceptOptions {
+	opts := &websocket.AcceptOptions{}
+	for _, origin := range cfg.conf.CORS.AllowedOrigins {
+		if origin == "*" {
+			opts.InsecureSkipVerify = true
+		}
+		opts.OriginPatterns = append(opts.OriginPatterns, origin)
+	}
+	return opts
+}
+
+type wsClient struct {
+	cfg  *apiConfig
+	conn *websocket.Conn
+
+	mu       sync.Mutex
+	userID   uuid.UUID
+	expires  time.Time
+	channels map[string]wsChannel
+
+	// reauthed is signalled when the client sends a new token.
+	reauthed chan struct{}
+}
+
+// authenticate accepts token as the client's credentials. A new token must
+// be for the user the connection started with.
+func (c *wsClient) authenticate(token string) error {
+	userID, err := auth.ValidateJWT(token, c.cfg.secret)
+	if err != nil {
+		return newAppError(codeInvalidToken, "Access token is invalid or has expired.", err)
+	}
+	expires, err := auth.JWTExpiry(token)
+	if err != nil {
+		return newAppError(codeInvalidToken, "Access token is invalid or has expired.", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.userID != uuid.Nil && c.userID != userID {
+		return newAppError(codeForbidden, "The token is for another user.", nil)
+	}
+	c.userID, c.expires = userID, expires
+	return nil
+}
+
+func (c *wsClient) session() (uuid.UUID, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userID, c.expires
+}
+
+// matching returns the subscribed channels e belongs to, in order.
+func (c *wsClient) matching(e pubsub.Event) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var names []string
+	for name, ch := range c.channels {
+		if ch.matches(e, c.userID) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (c *wsClient) send(ctx context.Context, msg wsMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+	defer cancel()
+	return wsjson.Write(ctx, c.conn, msg)
+}
+
+func (c *wsClient) sendError(ctx context.Context, err error) error {
+	appErr := toAppError(err)
+	return c.send(ctx, wsMessage{Type: "error", Code: appErr.code, Message: appErr.detail})
+}
+
+func (c *wsClient) sendReady(ctx context.Context) error {
+	userID, expires := c.session()
+	return c.send(ctx, wsMessage{Type: "ready", UserID: userID, ExpiresAt: expires})
+}
+
+// run serves the connection until it should close, and returns the status
+// to close it with.
+func (c *wsClient) run(ctx context.Context) (websocket.StatusCode, string) {
+	if userID, _ := c.session(); userID == uuid.Nil {
+		if err := c.awaitAuth(ctx); err != nil {
+			c.sendError(ctx, err)
+			return wsCloseUnauthenticated, "authentication failed"
+		}
+	}
+	if err := c.sendReady(ctx); err != nil {
+		return websocket.StatusInternalError, "write failed"
+	}
+
+	sub, _ := c.cfg.hub.Subscribe("", func(e pubsub.Event) bool { return len(c.matching(e)) > 0 })
+	defer sub.Close()
+
+	readErr := make(chan error, 1)
+	go func() { readErr <- c.readLoop(ctx) }()
+
+	ping := time.NewTicker(time.Duration(c.cfg.conf.Stream.Heartbeat))
+	defer ping.Stop()
+	warned := false
+	expiry := time.NewTimer(c.untilReauth(warned))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case err := <-readErr:
+			if websocket.CloseStatus(err) != -1 {
+				return websocket.StatusNormalClosure, ""
+			}
+			return websocket.StatusPolicyViolation, "read failed"
+
+		case e, ok := <-sub.C:
+			if !ok {
+				if errors.Is(sub.Err(), pubsub.ErrSlow) {
+					return websocket.StatusTryAgainLater, "client too slow"
+				}
+				return websocket.StatusGoingAway, "server shutting down"
+			}
+			channels := c.matching(e)
+			if len(channels) == 0 {
+				// Unsubscribed since it was queued.
+				continue
+			}
+			err := c.send(ctx, wsMessage{Type: "event", Channels: channels, ID: e.ID, Event: e.Type, Data: e.Data})
+			if err != nil {
+				return websocket.StatusTryAgainLater, "client too slow"
+			}
+
+		case <-ping.C:
+			pingCtx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+			err := c.conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return websocket.StatusGoingAway, "ping timed out"
+			}
+
+		case <-c.reauthed:
+			warned = false
+			expiry.Reset(c.untilReauth(warned))
+
+		case <-expiry.C:
+			if _, expires := c.session(); !time.Now().Before(expires) {
+				return wsCloseTokenExpired, "access token expired"
+			}
+			_, expires := c.session()
+			if err := c.send(ctx, wsMessage{Type: "reauth", ExpiresAt: expires}); err != nil {
+				return websocket.StatusTryAgainLater, "client too slow"
+			}
+			warned = true
+			expiry.Reset(c.untilReauth(warned))
+		}
+	}
+}
+
+// untilReauth is how long until the client should be asked for a new token
+// or, once it has been asked, until its token expires.
+func (c *wsClient) untilReauth(warned bool) time.Duration {
+	_, expires := c.session()
+	if warned {
+		return time.Until(expires)
+	}
+	return time.Until(expires.Add(-wsReauthWarning))
+}
+
+// awaitAuth reads the client's first message, which must carry a token.
+func (c *wsClient) awaitAuth(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, wsAuthTimeout)
+	defer cancel()
+	var req wsRequest
+	if err := wsjson.Read(ctx, c.conn, &req); err != nil {
+		return newAppError(codeUnauthenticated, "Send an auth message first.", err)
+	}
+	if req.Type != "auth" {
+		return newAppError(codeUnauthenticated, "Send an auth message first.", nil)
+	}
+	return c.authenticate(req.Token)
+}
+
+// readLoop handles the client's messages until the connection fails.
+// Messages the server can't act on get an error reply.
+func (c *wsClient) readLoop(ctx context.Context) error {
+	for {
+		_, dat, err := c.conn.Read(ctx)
+		if err != nil {
+			return err
+		}
+		var req wsRequest
+		if err = json.Unmarshal(dat, &req); err != nil {
+			err = newAppError(codeMalformedBody, "Messages must be JSON objects.", err)
+		} else {
+			err = c.handle(ctx, req)
+		}
+		if err != nil {
+			if err := c.sendError(ctx, err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *wsClient) handle(ctx context.Context, req wsRequest) error {
+	switch req.Type {
+	case "auth":
+		if err := c.authenticate(req.Token); err != nil {
+			return err
+		}
+		select {
+		case c.reauthed <- struct{}{}:
+		default:
+		}
+		return c.sendReady(ctx)
+
+	case "subscribe":
+		ch, err := parseChannel(req.Channel)
+		if err != nil {
+			return newAppError(codeInvalidParameter, err.Error(), err)
+		}
+		c.mu.Lock()
+		full := len(c.channels) >= wsMaxChannels
+		if !full {
+			c.channels[ch.String()] = ch
+		}
+		c.mu.Unlock()
+		if full {
+			return newAppError(codeInvalidParameter, fmt.Sprintf("A connection may subscribe to at most %d channels.", wsMaxChannels), nil)
+		}
+		return c.send(ctx, wsMessage{Type: "subscribed", Channel: ch.String()})
+
+	case "unsubscribe":
+		ch, err := parseChannel(req.Channel)
+		if err != nil {
+			return newAppError(codeInvalidParameter, err.Error(), err)
+		}
+		c.mu.Lock()
+		delete(c.channels, ch.String())
+		c.mu.Unlock()
+		return c.send(ctx, wsMessage{Type: "unsubscribed", Channel: ch.String()})
+	}
+	return newAppError(codeMalformedBody, fmt.Sprintf("Unknown message type %q; use auth, subscribe or unsubscribe.", req.Type), nil)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/05blue04/chirpy/internal/auth"
+	"github.com/05blue04/chirpy/internal/store"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/google/uuid"
+)
+
+func TestParseChannel(t *testing.T) {
+	id := uuid.New()
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "home", want: "home"},
+		{name: "notifications", want: "notifications"},
+		{name: "user:" + id.String(), want: "user:" + id.String()},
+		{name: "hashtag:Go", want: "hashtag:go"},
+		{name: "hashtag:#go", want: "hashtag:go"},
+		{name: "hashtag:", wantErr: true},
+		{name: "hashtag:two words", wantErr: true},
+		{name: "user:walt", wantErr: true},
+		{name: "everything", wantErr: true},
+	}
+	for _, tt := range tests {
+		ch, err := parseChannel(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseChannel(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && ch.String() != tt.want {
+			t.Errorf("parseChannel(%q) = %q, want %q", tt.name, ch, tt.want)
+		}
+	}
+}
+
+// wsTestConn is a WebSocket client for tests.
+type wsTestConn struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dialWS(t *testing.T, c *testClient, header http.Header) *wsTestConn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(c.srv.URL, "http") + "/api/v1/ws"
+	conn, _, err := websocket.Dial(context.Background(), url, &websocket.DialOptions{HTTPHeader: header})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return &wsTestConn{t: t, conn: conn}
+}
+
+func (ws *wsTestConn) send(req wsRequest) {
+	ws.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := wsjson.Write(ctx, ws.conn, req); err != nil {
+		ws.t.Fatal(err)
+	}
+}
+
+func (ws *wsTestConn) receive() wsMessage {
+	ws.t.Helper()
+	msg, err := ws.read()
+	if err != nil {
+		ws.t.Fatal(err)
+	}
+	return msg
+}
+
+func (ws *wsTestConn) read() (wsMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var msg wsMessage
+	err := wsjson.Read(ctx, ws.conn, &msg)
+	return msg, err
+}
+
+// expect fails the test unless the next message has the given type.
+func (ws *wsTestConn) expect(msgType string) wsMessage {
+	ws.t.Helper()
+	msg := ws.receive()
+	if msg.Type != msgType {
+		ws.t.Fatalf("got %+v, want a %s message", msg, msgType)
+	}
+	return msg
+}
+
+// expectClose fails the test unless the server closes the connection with
+// status.
+func (ws *wsTestConn) expectClose(status websocket.StatusCode) {
+	ws.t.Helper()
+	for {
+		msg, err := ws.read()
+		if err != nil {
+			if got := websocket.CloseStatus(err); got != status {
+				ws.t.Fatalf("closed with %v (%v), want %v", got, err, status)
+			}
+			return
+		}
+		if msg.Type != "error" && msg.Type != "reauth" {
+			ws.t.Fatalf("got %+v, want the connection closed", msg)
+		}
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	c := newTestClient(t, cfg)
+
+	walt := c.signup("walt@example.com", "04234")
+	jesse := c.signup("jesse@example.com", "abq")
+	ws := c.login("walt@example.com", "04234")
+	js := c.login("jesse@example.com", "abq")
+
+	conn := dialWS(t, c, http.Header{"Authorization": {bearer(ws.Token)}})
+	if ready := conn.expect("ready"); ready.UserID != walt.ID || ready.ExpiresAt.IsZero() {
+		t.Errorf("ready = %+v", ready)
+	}
+	for _, ch := range []string{"home", "user:" + jesse.ID.String(), "hashtag:#BlueSky", "notifications"} {
+		conn.send(wsRequest{Type: "subscribe", Channel: ch})
+		conn.expect("subscribed")
+	}
+
+	chirp := c.createChirp(js.Token, "cooking #bluesky tonight")
+	event := conn.expect("event")
+	if event.Event != eventChirpCreated || event.ID == "" {
+		t.Errorf("event = %+v", event)
+	}
+	if want := []string{"hashtag:bluesky", "home", "user:" + jesse.ID.String()}; strings.Join(event.Channels, ",") != strings.Join(want, ",") {
+		t.Errorf("event channels = %q, want %q", event.Channels, want)
+	}
+	if !strings.Contains(string(event.Data), chirp.ID.String()) {
+		t.Errorf("event data = %s", event.Data)
+	}
+
+	// Unsubscribing narrows what arrives.
+	conn.send(wsRequest{Type: "unsubscribe", Channel: "home"})
+	conn.expect("unsubscribed")
+	c.createChirp(ws.Token, "nobody asked")
+	c.expect(c.do("DELETE", "/api/v1/chirps/"+chirp.ID.String(), bearer(js.Token), nil), http.StatusNoContent, nil)
+	if deleted := conn.expect("event"); deleted.Event != eventChirpDeleted {
+		t.Errorf("got %+v, want only the delete of the followed chirp", deleted)
+	}
+
+	c.expect(c.send("POST", "/api/v1/polka/webhooks", "ApiKey "+testPolkaKey, "application/json",
+		[]byte(`{"event": "user.upgraded", "data": {"user_id": "`+walt.ID.String()+`"}}`)), http.StatusNoContent, nil)
+	if n := conn.expect("event"); n.Event != eventUserUpgraded || strings.Join(n.Channels, ",") != "notifications" {
+		t.Errorf("notification = %+v", n)
+	}
+
+	for name, req := range map[string]wsRequest{
+		"unknown channel":   {Type: "subscribe", Channel: "everything"},
+		"unknown type":      {Type: "publish"},
+		"another user":      {Type: "auth", Token: js.Token},
+		"invalid new token": {Type: "auth", Token: "garbage"},
+	} {
+		conn.send(req)
+		if msg := conn.expect("error"); msg.Code == "" || msg.Message == "" {
+			t.Errorf("%s: error = %+v", name, msg)
+		}
+	}
+}
+
+func TestWebSocketAuth(t *testing.T) {
+	cfg := newTestConfig(t, store.NewMemory(), nil, 0)
+	c := newTestClient(t, cfg)
+	u := c.signup("walt@example.com", "04234")
+	token := func(d time.Duration) string {
+		tok, err := auth.MakeJWT(u.ID, testSecret, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	t.Run("bad handshake token", func(t *testing.T) {
+		url := "ws" + strings.TrimPrefix(c.srv.URL, "http") + "/api/v1/ws"
+		_, resp, err := websocket.Dial(context.Background(), url, &websocket.DialOptions{
+			HTTPHeader: http.Header{"Authorization": {"Bearer garbage"}},
+		})
+		if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("dial error = %v, response %+v, want a 401", err, resp)
+		}
+	})
+
+	t.Run("subscribe before auth", func(t *testing.T) {
+		conn := dialWS(t, c, nil)
+		conn.send(wsRequest{Type: "subscribe", Channel: "home"})
+		conn.expect("error")
+		conn.expectClose(wsCloseUnauthenticated)
+	})
+
+	t.Run("reauth", func(t *testing.T) {
+		// Tokens this short are due for renewal at once.
+		conn := dialWS(t, c, nil)
+		conn.send(wsRequest{Type: "auth", Token: token(2 * time.Second)})
+		conn.expect("ready")
+		conn.expect("reauth")
+
+		conn.send(wsRequest{Type: "auth", Token: token(time.Hour)})
+		if ready := conn.expect("ready"); time.Until(ready.ExpiresAt) < 50*time.Minute {
+			t.Errorf("ready after reauth expires at %v", ready.ExpiresAt)
+		}
+		time.Sleep(2 * time.Second)
+		conn.send(wsRequest{Type: "subscribe", Channel: "home"})
+		conn.expect("subscribed")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		conn := dialWS(t, c, nil)
+		conn.send(wsRequest{Type: "auth", Token: token(time.Second)})
+		conn.expect("ready")
+		conn.expectClose(wsCloseTokenExpired)
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		conn := dialWS(t, c, http.Header{"Authorization": {bearer(token(time.Hour))}})
+		conn.expect("ready")
+		cfg.hub.Close()
+		conn.expectClose(websocket.StatusGoingAway)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// workerGroup runs long-lived background goroutines that share a context
+// cancelled on shutdown, so main can wait for them to finish.
+type workerGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	status map[string]workerStatus
+}
+
+type workerStatus struct {
+	State     string    `json:"state"`
+	StartedAt time.Time `json:"started_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+const (
+	workerRunning = "running"
+	workerStopped = "stopped"
+	workerFailed  = "failed"
+)
+
+func newWorkerGroup() *workerGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workerGroup{
+		ctx:    ctx,
+		cancel: cancel,
+		status: map[string]workerStatus{},
+	}
+}
+
+// Go starts fn in the background. fn should return promptly once ctx is
+// cancelled; returning before that is reported as a failure.
+func (g *workerGroup) Go(name string, fn func(ctx context.Context) error) {
+	g.setStatus(name, workerStatus{State: workerRunning, StartedAt: time.Now()})
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		err := fn(g.ctx)
+
+		st := g.Status()[name]
+		if g.ctx.Err() != nil {
+			st.State = workerStopped
+		} else {
+			st.State = workerFailed
+			if err != nil {
+				st.Error = err.Error()
+			} else {
+				st.Error = "exited unexpectedly"
+			}
+			slog.Error("background worker stopped", "worker", name, "error", st.Error)
+		}
+		g.setStatus(name, st)
+	}()
+}
+
+func (g *workerGroup) setStatus(name string, st workerStatus) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.status[name] = st
+}
+
+// Status returns a snapshot of every worker started with Go.
+func (g *workerGroup) Status() map[string]workerStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make(map[string]workerStatus, len(g.status))
+	for name, st := range g.status {
+		out[name] = st
+	}
+	return out
+}
+
+// Shutdown cancels the workers and waits for them to return or for ctx to
+// expire, whichever comes first.
+func (g *workerGroup) Shutdown(ctx context.Context) error {
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}